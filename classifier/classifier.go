@@ -0,0 +1,213 @@
+// Package classifier runs a configurable set of detectors against
+// each comment and combines their signals into one score.
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strings"
+)
+
+// Method of verdicts holding the combined score of all detectors
+const MethodCombined = "combined"
+
+// What a detector found out about a comment. Scores range from 0
+//  (nothing suspicious) to 1.
+type Signal struct {
+	Detector  string
+	Score     float64
+	Band      string
+	Evidence  string
+	MatchedID string // comment the signal refers to, if any
+}
+
+// A Detector looks at one comment and returns a signal, or nil if
+//  there is nothing to report
+type Detector interface {
+	Name() string
+	Detect(ctx context.Context, comment *fbbot.FBComment) (*Signal, error)
+}
+
+// What detectors get to work with
+type Env struct {
+	ES     *es.ES
+	Store  db.Store
+	Config *config.ClassifyConfig
+}
+
+// Detectors by the name used in the detectors setting
+var factories = map[string]func(ctx context.Context, env *Env) (Detector, error){}
+
+func register(name string, factory func(ctx context.Context, env *Env) (Detector, error)) {
+	factories[name] = factory
+}
+
+// Thresholds for the combined score unless configured
+const (
+	DefaultSuspectThreshold = 0.5
+	DefaultMatchThreshold   = 0.8
+)
+
+// Runs detectors and combines their signals
+type Runner struct {
+	Config           *config.ClassifyConfig
+	detectors        []Detector
+	suspectThreshold float64
+	matchThreshold   float64
+}
+
+// Everything the detectors found out about a comment
+type Result struct {
+	CommentID string
+	UserID    string
+	Signals   []Signal
+	Score     float64
+	Band      string
+}
+
+// Setup the configured detectors (mlt if none are configured)
+func New(ctx context.Context, env *Env) (*Runner, error) {
+	names := env.Config.Detectors
+	if len(names) == 0 {
+		names = []string{fbbot.MethodMLT}
+	}
+
+	r := &Runner{
+		Config:           env.Config,
+		detectors:        make([]Detector, 0, len(names)),
+		suspectThreshold: DefaultSuspectThreshold,
+		matchThreshold:   DefaultMatchThreshold,
+	}
+	if env.Config.SuspectThreshold > 0 {
+		r.suspectThreshold = env.Config.SuspectThreshold
+	}
+	if env.Config.MatchThreshold > 0 {
+		r.matchThreshold = env.Config.MatchThreshold
+	}
+	for _, name := range names {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown detector '%s'", name)
+		}
+		detector, err := factory(ctx, env)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot setup detector %s: %s", name, err)
+		}
+		log.Infof("Using detector %s (weight %1.2f)", name, r.weight(name))
+		r.detectors = append(r.detectors, detector)
+	}
+
+	return r, nil
+}
+
+// Weight of a detector's score, 1 unless configured
+func (r *Runner) weight(name string) float64 {
+	if w, ok := r.Config.Weights[name]; ok {
+		return w
+	}
+	return 1
+}
+
+// Run all detectors against a comment. Detectors which fail (most
+//  likely because ElasticSearch or the database are unreachable) are
+//  left out of the result and reported in the error, the comment
+//  should be classified again later.
+//
+// Signals are combined like independent pieces of evidence: each
+//  weighted score (capped at 1) is the chance that detector alone
+//  is right, and the comment scores the chance that at least one of
+//  them is. A single detector at full score is a match, several weak
+//  signals add up, and detectors without a signal don't lower the
+//  score.
+func (r *Runner) Run(ctx context.Context, comment *fbbot.FBComment) (*Result, error) {
+	result := &Result{
+		CommentID: comment.ID,
+		UserID:    comment.From.ID,
+		Signals:   make([]Signal, 0),
+		Band:      fbbot.BandDiscard,
+	}
+
+	failed := make([]string, 0)
+	innocent := 1.0
+	for _, detector := range r.detectors {
+		detectCtx, span := tracing.Start(ctx, "detect "+detector.Name())
+		signal, err := detector.Detect(detectCtx, comment)
+		tracing.End(span, err)
+		if err != nil {
+			logging.Entry(ctx).Errorf("Detector %s failed on comment %s: %s", detector.Name(), comment.ID, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", detector.Name(), err))
+			continue
+		}
+		if signal == nil {
+			continue
+		}
+
+		logging.Entry(ctx).Debugf("Detector %s: score %1.3f (%s) - %s", signal.Detector, signal.Score, signal.Band, signal.Evidence)
+		result.Signals = append(result.Signals, *signal)
+		innocent *= 1 - math.Min(1, r.weight(detector.Name())*signal.Score)
+	}
+
+	result.Score = 1 - innocent
+	switch {
+	case result.Score >= r.matchThreshold:
+		result.Band = fbbot.BandMatch
+	case result.Score >= r.suspectThreshold:
+		result.Band = fbbot.BandSuspect
+	}
+
+	if len(failed) > 0 {
+		return result, fmt.Errorf("Detectors failed on comment %s: %s", comment.ID, strings.Join(failed, ", "))
+	}
+	return result, nil
+}
+
+// Band of a single detector's score, for detectors without
+//  thresholds of their own
+func scoreBand(score float64) string {
+	switch {
+	case score >= DefaultMatchThreshold:
+		return fbbot.BandMatch
+	case score >= DefaultSuspectThreshold:
+		return fbbot.BandSuspect
+	}
+	return fbbot.BandDiscard
+}
+
+// Verdicts to store: one per signal and the combined score, leaving
+//  out whatever was discarded
+func (r *Result) Verdicts() []*fbbot.Verdict {
+	verdicts := make([]*fbbot.Verdict, 0, len(r.Signals)+1)
+	for _, signal := range r.Signals {
+		if signal.Band == fbbot.BandDiscard {
+			continue
+		}
+		verdicts = append(verdicts, &fbbot.Verdict{
+			CommentID: r.CommentID,
+			UserID:    r.UserID,
+			MatchedID: signal.MatchedID,
+			Score:     signal.Score,
+			Band:      signal.Band,
+			Method:    signal.Detector,
+		})
+	}
+
+	if r.Band != fbbot.BandDiscard {
+		verdicts = append(verdicts, &fbbot.Verdict{
+			CommentID: r.CommentID,
+			UserID:    r.UserID,
+			Score:     r.Score,
+			Band:      r.Band,
+			Method:    MethodCombined,
+		})
+	}
+
+	return verdicts
+}
@@ -0,0 +1,157 @@
+package classifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/lang"
+	"github.com/moensch/fbbotscan/similarity"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+	"io"
+	"sync"
+)
+
+func init() {
+	register(fbbot.MethodMinhash, func(ctx context.Context, env *Env) (Detector, error) {
+		log.Printf("Discarding near-duplicates less similar than %1.3f", env.Config.DiscardSimilarity)
+		m := &MinhashDetector{
+			indices: make(map[string]*similarity.Index),
+			appdb:   env.Store,
+			cfg:     env.Config,
+		}
+		if err := m.load(ctx, env.ES); err != nil {
+			log.Errorf("Cannot load recent comments into the minhash index: %s", err)
+		}
+		for language, index := range m.indices {
+			log.Infof("Minhash index for language '%s' holds %d comments", language, index.Len())
+		}
+
+		return m, nil
+	})
+}
+
+// Finds near-duplicates in a local minhash index and groups them
+//  into campaigns. The signal score is the estimated Jaccard
+//  similarity. Comments are only compared with others in the same
+//  language, short texts in different languages can share enough
+//  shingles to collide. The index is per process, so only one
+//  classifier may run with this detector.
+type MinhashDetector struct {
+	sync.Mutex
+	indices map[string]*similarity.Index // by language
+	appdb   db.Store
+	cfg     *config.ClassifyConfig
+}
+
+// Index of the comments in a language, created on first use
+func (m *MinhashDetector) index(language string) *similarity.Index {
+	m.Lock()
+	defer m.Unlock()
+	index, ok := m.indices[language]
+	if !ok {
+		index = similarity.NewIndex(m.cfg.MinhashDocuments)
+		m.indices[language] = index
+	}
+	return index
+}
+
+func (m *MinhashDetector) Name() string {
+	return fbbot.MethodMinhash
+}
+
+// Matches come back most similar first, anything below
+//  discard_similarity is left out
+func (m *MinhashDetector) Detect(ctx context.Context, entry *fbbot.FBComment) (*Signal, error) {
+	if entry.Message == "" {
+		return nil, nil
+	}
+	index := m.index(entry.Language)
+	defer index.Add(entry.ID, entry.Message)
+
+	for _, match := range index.Query(entry.Message, m.cfg.DiscardSimilarity) {
+		if match.ID == entry.ID {
+			log.Debugf("Discarding match which is the same comment ID")
+			continue
+		}
+
+		band := fbbot.BandSuspect
+		if match.Similarity >= m.cfg.MatchSimilarity {
+			band = fbbot.BandMatch
+		}
+		log.Warnf("Found near-duplicate comment %s, similarity %1.3f (%s)", match.ID, match.Similarity, band)
+
+		signal := &Signal{
+			Detector:  fbbot.MethodMinhash,
+			Score:     match.Similarity,
+			Band:      band,
+			Evidence:  fmt.Sprintf("Near-duplicate of comment %s, similarity %1.3f", match.ID, match.Similarity),
+			MatchedID: match.ID,
+		}
+
+		if m.cfg.CampaignSimilarity > 0 && match.Similarity >= m.cfg.CampaignSimilarity {
+			campaign, err := m.appdb.WithContext(ctx).AddToCampaign(entry.ID, match.ID, entry.Message, match.Similarity)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot add comment %s to a campaign: %s", entry.ID, err)
+			}
+			log.Warnf("Comment %s belongs to %s", entry.ID, campaign)
+			signal.Evidence = fmt.Sprintf("%s, %s", signal.Evidence, campaign)
+		}
+
+		return signal, nil
+	}
+
+	return nil, nil
+}
+
+// Fill the minhash indices with the most recent comments from
+//  ElasticSearch, oldest first so they are also dropped first.
+//  Comments stored without a language get one like in the pipeline.
+func (m *MinhashDetector) load(ctx context.Context, client *es.ES) error {
+	type doc struct {
+		ID       string `json:"id"`
+		Message  string `json:"message"`
+		Language string `json:"language"`
+	}
+
+	cfg := m.cfg
+	docs := make([]doc, 0)
+	scroll := client.Client.Scroll(searchIndices(client, cfg.LookbackDays)...).
+		IgnoreUnavailable(true).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("id", "message", "language")).
+		Sort("created_time", false).
+		Size(1000)
+	defer scroll.Clear(ctx)
+
+	for cfg.MinhashDocuments <= 0 || len(docs) < cfg.MinhashDocuments {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Scroll failed: %s", err)
+		}
+
+		for _, hit := range res.Hits.Hits {
+			var d doc
+			if err := json.Unmarshal(*hit.Source, &d); err != nil {
+				return fmt.Errorf("Cannot deserialize search result: %s", err)
+			}
+			docs = append(docs, d)
+		}
+	}
+
+	for i := len(docs) - 1; i >= 0; i-- {
+		language := docs[i].Language
+		if language == lang.Unknown {
+			language = lang.Detect(docs[i].Message)
+		}
+		m.index(language).Add(docs[i].ID, docs[i].Message)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package classifier
+
+import (
+	"context"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/lang"
+	"github.com/moensch/fbbotscan/similarity"
+	"testing"
+)
+
+func TestMinhashLanguages(t *testing.T) {
+	const message = "Stop the steal, share this before they delete it"
+
+	tests := []struct {
+		name     string
+		seen     string
+		language string
+		want     string
+	}{
+		{"same language", lang.English, lang.English, "1"},
+		{"both unknown", lang.Unknown, lang.Unknown, "1"},
+		{"other language", lang.German, lang.English, ""},
+		{"known and unknown", lang.Unknown, lang.English, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MinhashDetector{
+				indices: make(map[string]*similarity.Index),
+				cfg:     &config.ClassifyConfig{DiscardSimilarity: 0.5, MatchSimilarity: 0.8},
+			}
+			ctx := context.Background()
+
+			seen := &fbbot.FBComment{ID: "1", Message: message, Language: tt.seen}
+			if signal, err := m.Detect(ctx, seen); err != nil || signal != nil {
+				t.Fatalf("first comment: %v, %v", signal, err)
+			}
+
+			signal, err := m.Detect(ctx, &fbbot.FBComment{ID: "2", Message: message, Language: tt.language})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if signal != nil {
+				got = signal.MatchedID
+			}
+			if got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package classifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/lang"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+	"time"
+)
+
+func init() {
+	register(fbbot.MethodMLT, func(ctx context.Context, env *Env) (Detector, error) {
+		log.Printf("Discarding matches scoring lower than %3.5f", env.Config.DiscardScore)
+		log.Printf("Considering matches scoring higher ghan %3.5f", env.Config.MatchScore)
+		return &MLTDetector{client: env.ES, cfg: env.Config}, nil
+	})
+}
+
+// Finds similar comments with an ElasticSearch more_like_this query,
+//  using the analyzer of the comment's language and only matching
+//  comments in the same language. The signal score is the MLT score
+//  relative to match_score.
+type MLTDetector struct {
+	client *es.ES
+	cfg    *config.ClassifyConfig
+}
+
+func (m *MLTDetector) Name() string {
+	return fbbot.MethodMLT
+}
+
+func (m *MLTDetector) Detect(ctx context.Context, entry *fbbot.FBComment) (*Signal, error) {
+	if entry.Message == "" {
+		return nil, nil
+	}
+
+	// Using MLT query
+	// GET _search
+	// {
+	//   "query": {
+	//     "more_like_this" : {
+	//       "fields" : ["message"],
+	//       "like" : ["Lorem ipsum dolor sit amet, consectetur adipiscing elit. rdiet nulla. Vestibulum ac ex rhoncus, semper nisi ut, consequat metus. Mauris dignissim dignissim ex, id condimentum mauris. Morbi cursus sapien vel justo convallis, vitae commodo est dignissim. Nulla ut lorem nec mauris blandit volutpat vitae sit amet velit. Nullam sit amet consequat quam. Proin ut augue porta, consequat sapien sodalque tortor. Donec vel diam cursus, facilisis neque ac, dignissim purus. Praesent fringilla at est in luctus. Nunc pulvinar."],
+	//       "min_term_freq" : 1,
+	//       "max_query_terms": 150,
+	//       "min_doc_freq":1
+	//     }
+	//   }
+	// }
+	field, analyzer := "message", "standard"
+	if a := lang.Analyzer(entry.Language); a != "" {
+		field, analyzer = "message."+a, a
+	}
+	mlt := elastic.NewMoreLikeThisQuery().
+		Analyzer(analyzer).
+		Field(field).
+		LikeText(entry.Message).
+		MinDocFreq(1).
+		MaxQueryTerms(150).
+		MinTermFreq(1).
+		MinimumShouldMatch("60%")
+
+	// Comments of unknown language are stored without one
+	query := elastic.NewBoolQuery().Must(mlt)
+	if entry.Language == lang.Unknown {
+		query = query.MustNot(elastic.NewExistsQuery("language"))
+	} else {
+		query = query.Filter(elastic.NewTermQuery("language", entry.Language))
+	}
+
+	searchResult, err := m.client.Client.Search().
+		Index(searchIndices(m.client, m.cfg.LookbackDays)...).
+		IgnoreUnavailable(true).
+		Query(query).
+		Pretty(true).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Search failure: %s", err)
+	}
+
+	log.Debugf("Query took %d milliseconds", searchResult.TookInMillis)
+	log.Debugf("Found a total of %d matches", searchResult.TotalHits())
+	/*
+		var ttyp fbbot.FBComment
+		for _, item := range searchResult.Each(reflect.TypeOf(ttyp)) {
+			if t, ok := item.(fbbot.FBComment); ok {
+				log.Infof("FBComment by %s: %s\n", t.From.ID, t.Message)
+			}
+		}
+	*/
+
+	// Best match which wasn't discarded
+	var signal *Signal
+	var best float64
+	for _, hit := range searchResult.Hits.Hits {
+		var comment fbbot.FBComment
+		err := json.Unmarshal(*hit.Source, &comment)
+		if err != nil {
+			log.Errorf("Cannot deserialize search result: %s", err)
+			continue
+		}
+		if comment.ID == entry.ID {
+			log.Debugf("Discarding match which is the same comment ID")
+			continue
+		}
+
+		var band string
+		switch {
+		case *hit.Score < m.cfg.DiscardScore:
+			band = fbbot.BandDiscard
+			log.Infof("Found matching comment in Index %s, score %3.5f, ID %s from %s: %s", hit.Index, *hit.Score, comment.ID, comment.From.ID, comment.Message)
+		case *hit.Score > m.cfg.MatchScore:
+			band = fbbot.BandMatch
+			log.Errorf("Found matching comment in Index %s, score %3.5f, ID %s from %s: %s", hit.Index, *hit.Score, comment.ID, comment.From.ID, comment.Message)
+		default:
+			band = fbbot.BandSuspect
+			log.Warnf("Found matching comment in Index %s, score %3.5f, ID %s from %s: %s", hit.Index, *hit.Score, comment.ID, comment.From.ID, comment.Message)
+		}
+
+		if band != fbbot.BandDiscard && (signal == nil || *hit.Score > best) {
+			best = *hit.Score
+			score := 1.0
+			if m.cfg.MatchScore > 0 && best < m.cfg.MatchScore {
+				score = best / m.cfg.MatchScore
+			}
+			signal = &Signal{
+				Detector:  fbbot.MethodMLT,
+				Score:     score,
+				Band:      band,
+				Evidence:  fmt.Sprintf("MLT score %3.5f against comment %s in %s", best, comment.ID, hit.Index),
+				MatchedID: comment.ID,
+			}
+		}
+	}
+
+	return signal, nil
+}
+
+// Comment indices within the lookback window, all of them if
+//  there is no window
+func searchIndices(client *es.ES, lookbackDays int) []string {
+	if lookbackDays <= 0 {
+		return []string{es.CommentSearchAlias}
+	}
+	return client.CommentIndices().Since(time.Now().AddDate(0, 0, -lookbackDays))
+}
@@ -0,0 +1,44 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+)
+
+const DetectorNetwork = "network"
+
+func init() {
+	register(DetectorNetwork, func(ctx context.Context, env *Env) (Detector, error) {
+		return &NetworkDetector{appdb: env.Store}, nil
+	})
+}
+
+// Flags authors who are part of a coordinated network found by
+//  fb-network. The score is the density of the network.
+type NetworkDetector struct {
+	appdb db.Store
+}
+
+func (n *NetworkDetector) Name() string {
+	return DetectorNetwork
+}
+
+func (n *NetworkDetector) Detect(ctx context.Context, entry *fbbot.FBComment) (*Signal, error) {
+	if entry.From.ID == "" {
+		return nil, nil
+	}
+
+	network, err := n.appdb.WithContext(ctx).GetUserNetwork(entry.From.ID)
+	if err != nil || network == nil {
+		return nil, err
+	}
+
+	return &Signal{
+		Detector: DetectorNetwork,
+		Score:    network.Density,
+		Band:     scoreBand(network.Density),
+		Evidence: fmt.Sprintf("Member of network %d with %d authors, density %1.3f", network.ID, len(network.Members), network.Density),
+	}, nil
+}
@@ -0,0 +1,163 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"math"
+	"strings"
+	"time"
+)
+
+const DetectorReplies = "replies"
+
+// Defaults for the reply detector settings
+const (
+	defaultReplyWindowHours = 168
+	defaultMinReplies       = 5
+	defaultTargetShare      = 0.5
+	defaultPileOnSeconds    = 300
+	defaultPileOnAuthors    = 5
+	defaultSelfReplies      = 3
+)
+
+func init() {
+	register(DetectorReplies, func(ctx context.Context, env *Env) (Detector, error) {
+		r := &RepliesDetector{
+			appdb:         env.Store,
+			window:        time.Duration(orInt(env.Config.ReplyWindowHours, defaultReplyWindowHours)) * time.Hour,
+			minReplies:    orInt(env.Config.MinReplies, defaultMinReplies),
+			targetShare:   orFloat(env.Config.TargetShare, defaultTargetShare),
+			pileOn:        time.Duration(orInt(env.Config.PileOnSeconds, defaultPileOnSeconds)) * time.Second,
+			pileOnAuthors: orInt(env.Config.PileOnAuthors, defaultPileOnAuthors),
+			selfReplies:   orInt(env.Config.SelfReplies, defaultSelfReplies),
+		}
+		return r, nil
+	})
+}
+
+// Looks at reply threads: authors who keep replying to the same user,
+//  many authors piling onto one comment within minutes and authors
+//  replying to themselves. The score is that of the strongest of these.
+type RepliesDetector struct {
+	appdb         db.Store
+	window        time.Duration
+	minReplies    int
+	targetShare   float64
+	pileOn        time.Duration
+	pileOnAuthors int
+	selfReplies   int
+}
+
+func (r *RepliesDetector) Name() string {
+	return DetectorReplies
+}
+
+func (r *RepliesDetector) Detect(ctx context.Context, entry *fbbot.FBComment) (*Signal, error) {
+	if entry.From.ID == "" || entry.Parent.ID == "" {
+		return nil, nil
+	}
+
+	since, until := around(entry, r.window)
+	all, err := r.appdb.WithContext(ctx).GetUserReplies(entry.From.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]db.Reply, 0, len(all))
+	for _, reply := range all {
+		if !reply.CreatedTime.After(until) {
+			replies = append(replies, reply)
+		}
+	}
+	thread, err := r.appdb.WithContext(ctx).GetCommentReplies(entry.Parent.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var score float64
+	evidence := make([]string, 0)
+	add := func(s float64, why string) {
+		if s <= 0 {
+			return
+		}
+		evidence = append(evidence, why)
+		if s > score {
+			score = s
+		}
+	}
+	add(r.targetScore(replies))
+	add(r.selfScore(entry.From.ID, replies))
+	add(r.pileOnScore(entry, thread))
+
+	if score <= 0 {
+		return nil, nil
+	}
+
+	return &Signal{
+		Detector: DetectorReplies,
+		Score:    score,
+		Band:     scoreBand(score),
+		Evidence: strings.Join(evidence, ", "),
+	}, nil
+}
+
+// Share of an author's replies going to their most replied-to user,
+//  once above target_share
+func (r *RepliesDetector) targetScore(replies []db.Reply) (float64, string) {
+	if len(replies) < r.minReplies {
+		return 0, ""
+	}
+
+	targets := make(map[string]int)
+	var top string
+	for _, reply := range replies {
+		targets[reply.TargetUserID]++
+		if targets[reply.TargetUserID] > targets[top] {
+			top = reply.TargetUserID
+		}
+	}
+
+	share := float64(targets[top]) / float64(len(replies))
+	if share < r.targetShare {
+		return 0, ""
+	}
+	return share,
+		fmt.Sprintf("%d of %d replies to user %s", targets[top], len(replies), top)
+}
+
+// Replies to the author's own comments. Scores 0.5 at self_replies,
+//  1 at twice as many.
+func (r *RepliesDetector) selfScore(userID string, replies []db.Reply) (float64, string) {
+	self := 0
+	for _, reply := range replies {
+		if reply.TargetUserID == userID {
+			self++
+		}
+	}
+	if self < r.selfReplies {
+		return 0, ""
+	}
+	return math.Min(1, float64(self)/float64(2*r.selfReplies)),
+		fmt.Sprintf("%d replies to own comments", self)
+}
+
+// Distinct authors replying to the same comment within pile_on_seconds
+//  of this reply. Scores 0.5 at pile_on_authors, 1 at twice as many.
+func (r *RepliesDetector) pileOnScore(entry *fbbot.FBComment, thread []db.Reply) (float64, string) {
+	authors := make(map[string]bool)
+	for _, reply := range thread {
+		gap := reply.CreatedTime.Sub(entry.CreatedTime.Time)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= r.pileOn {
+			authors[reply.UserID] = true
+		}
+	}
+	if len(authors) < r.pileOnAuthors {
+		return 0, ""
+	}
+	return math.Min(1, float64(len(authors))/float64(2*r.pileOnAuthors)),
+		fmt.Sprintf("%d authors replied to comment %s within %s", len(authors), entry.Parent.ID, r.pileOn)
+}
@@ -0,0 +1,152 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"testing"
+	"time"
+)
+
+func repliesDetector(store db.Store) *RepliesDetector {
+	return &RepliesDetector{
+		appdb:         store,
+		window:        defaultReplyWindowHours * time.Hour,
+		minReplies:    defaultMinReplies,
+		targetShare:   defaultTargetShare,
+		pileOn:        defaultPileOnSeconds * time.Second,
+		pileOnAuthors: defaultPileOnAuthors,
+		selfReplies:   defaultSelfReplies,
+	}
+}
+
+// Replies by user to the given authors, a minute apart
+func repliesTo(user string, targets ...string) []db.Reply {
+	replies := make([]db.Reply, 0, len(targets))
+	for i, target := range targets {
+		replies = append(replies, db.Reply{UserID: user, TargetUserID: target, CreatedTime: at(i * 60)})
+	}
+	return replies
+}
+
+func TestTargetScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []db.Reply
+		want    float64
+	}{
+		{"too few replies", repliesTo("a", "x", "x", "x", "x"), 0},
+		{"always the same user", repliesTo("a", "x", "x", "x", "x", "x"), 1},
+		{"mostly the same user", repliesTo("a", "x", "y", "x", "z", "x", "x", "y", "x"), 5.0 / 8},
+		{"at target_share", repliesTo("a", "x", "y", "x", "z", "x", "w"), 0.5},
+		{"spread out", repliesTo("a", "x", "y", "z", "w", "v", "x"), 0},
+	}
+
+	d := repliesDetector(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.targetScore(tt.replies); got != tt.want {
+				t.Errorf("targetScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []db.Reply
+		want    float64
+	}{
+		{"none", repliesTo("a", "x", "y", "z"), 0},
+		{"below self_replies", repliesTo("a", "a", "x", "a"), 0},
+		{"at self_replies", repliesTo("a", "a", "a", "a"), 0.5},
+		{"capped at 1", repliesTo("a", "a", "a", "a", "a", "a", "a", "a"), 1},
+	}
+
+	d := repliesDetector(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.selfScore("a", tt.replies); got != tt.want {
+				t.Errorf("selfScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPileOnScore(t *testing.T) {
+	// Replies by different authors, seconds apart
+	thread := func(seconds int, authors ...string) []db.Reply {
+		replies := make([]db.Reply, 0, len(authors))
+		for i, author := range authors {
+			replies = append(replies, db.Reply{UserID: author, ParentID: "parent", CreatedTime: at(i * seconds)})
+		}
+		return replies
+	}
+
+	tests := []struct {
+		name   string
+		thread []db.Reply
+		want   float64
+	}{
+		{"too few authors", thread(10, "a", "b", "c", "d"), 0},
+		{"at pile_on_authors", thread(10, "a", "b", "c", "d", "e"), 0.5},
+		{"authors count once", thread(10, "a", "a", "a", "a", "a", "b"), 0},
+		{"capped at 1", thread(1, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"), 1},
+		{"too far apart", thread(200, "a", "b", "c", "d", "e"), 0},
+	}
+
+	d := repliesDetector(nil)
+	entry := &fbbot.FBComment{CreatedTime: fbbot.NewGraphTime(at(0))}
+	entry.Parent.ID = "parent"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.pileOnScore(entry, tt.thread); got != tt.want {
+				t.Errorf("pileOnScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepliesDetectWindow(t *testing.T) {
+	target := &fbbot.FBComment{ID: "target", PostID: "p1", CreatedTime: fbbot.NewGraphTime(at(-60))}
+	target.From.ID = "victim"
+	comments := []*fbbot.FBComment{target}
+	// Replies to the same user, an hour apart from start
+	for i := 0; i < 2*defaultMinReplies; i++ {
+		c := &fbbot.FBComment{ID: fmt.Sprintf("reply%d", i), PostID: "p1", CreatedTime: fbbot.NewGraphTime(at(i * 3600))}
+		c.From.ID = "troll"
+		c.Parent.ID = "target"
+		comments = append(comments, c)
+	}
+	d := repliesDetector(memoryStore(t, comments...))
+
+	tests := []struct {
+		name    string
+		created time.Time
+		parent  string
+		want    bool
+	}{
+		{"during the replies", at(0), "target", true},
+		{"a day later", at(24 * 3600), "target", true},
+		{"long after", at(9 * 24 * 3600), "target", false},
+		{"long before", at(-8 * 24 * 3600), "target", false},
+		{"not a reply", at(0), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &fbbot.FBComment{ID: "new", CreatedTime: fbbot.NewGraphTime(tt.created)}
+			entry.From.ID = "troll"
+			entry.Parent.ID = tt.parent
+			signal, err := d.Detect(context.Background(), entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (signal != nil) != tt.want {
+				t.Errorf("Detect() = %+v, want a signal: %v", signal, tt.want)
+			}
+		})
+	}
+}
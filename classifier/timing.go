@@ -0,0 +1,227 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"math"
+	"strings"
+	"time"
+)
+
+const DetectorTiming = "timing"
+
+// Defaults for the timing detector settings
+const (
+	defaultTimingWindowHours = 168
+	defaultBurstSeconds      = 60
+	defaultBurstComments     = 5
+	defaultRegularCV         = 0.3
+	defaultMinIdleHours      = 4
+
+	// Fewer gaps than this say nothing about regularity
+	minRegularGaps = 10
+	// Only look at the most recent gaps, cadences change
+	maxRegularGaps = 50
+	// Round-the-clock activity needs this many days of comments
+	minClockDays = 3
+)
+
+func init() {
+	register(DetectorTiming, func(ctx context.Context, env *Env) (Detector, error) {
+		t := &TimingDetector{
+			appdb:         env.Store,
+			window:        time.Duration(orInt(env.Config.TimingWindowHours, defaultTimingWindowHours)) * time.Hour,
+			burst:         time.Duration(orInt(env.Config.BurstSeconds, defaultBurstSeconds)) * time.Second,
+			burstComments: orInt(env.Config.BurstComments, defaultBurstComments),
+			regularCV:     orFloat(env.Config.RegularCV, defaultRegularCV),
+			minIdleHours:  orInt(env.Config.MinIdleHours, defaultMinIdleHours),
+		}
+		return t, nil
+	})
+}
+
+// Flags authors commenting at inhuman cadences: bursts across posts,
+//  timer-like regular gaps and activity around the clock. The score
+//  is that of the strongest of these.
+type TimingDetector struct {
+	appdb         db.Store
+	window        time.Duration
+	burst         time.Duration
+	burstComments int
+	regularCV     float64
+	minIdleHours  int
+}
+
+func (t *TimingDetector) Name() string {
+	return DetectorTiming
+}
+
+func (t *TimingDetector) Detect(ctx context.Context, entry *fbbot.FBComment) (*Signal, error) {
+	if entry.From.ID == "" {
+		return nil, nil
+	}
+
+	since, until := around(entry, t.window)
+	all, err := t.appdb.WithContext(ctx).GetUserComments(entry.From.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	comments := make([]db.UserComment, 0, len(all))
+	for _, c := range all {
+		if !c.CreatedTime.After(until) {
+			comments = append(comments, c)
+		}
+	}
+	if len(comments) < 2 {
+		return nil, nil
+	}
+
+	var score float64
+	evidence := make([]string, 0)
+	for _, check := range []func([]db.UserComment) (float64, string){t.burstScore, t.regularScore, t.clockScore} {
+		s, why := check(comments)
+		if s <= 0 {
+			continue
+		}
+		evidence = append(evidence, why)
+		if s > score {
+			score = s
+		}
+	}
+
+	if score <= 0 {
+		return nil, nil
+	}
+
+	return &Signal{
+		Detector: DetectorTiming,
+		Score:    score,
+		Band:     scoreBand(score),
+		Evidence: strings.Join(evidence, ", "),
+	}, nil
+}
+
+// Most comments within the burst interval spanning more than one
+//  post. Scores 0.5 at burst_comments, 1 at twice as many.
+func (t *TimingDetector) burstScore(comments []db.UserComment) (float64, string) {
+	most := 0
+	start := 0
+	for end := range comments {
+		for comments[end].CreatedTime.Sub(comments[start].CreatedTime) > t.burst {
+			start++
+		}
+
+		posts := make(map[string]bool)
+		for _, c := range comments[start : end+1] {
+			posts[c.PostID] = true
+		}
+		if len(posts) > 1 && end-start+1 > most {
+			most = end - start + 1
+		}
+	}
+
+	if most < t.burstComments {
+		return 0, ""
+	}
+	return math.Min(1, float64(most)/float64(2*t.burstComments)),
+		fmt.Sprintf("%d comments on different posts within %s", most, t.burst)
+}
+
+// Coefficient of variation of the recent gaps between comments.
+//  People are bursty (well above 1), timers are not.
+func (t *TimingDetector) regularScore(comments []db.UserComment) (float64, string) {
+	if len(comments)-1 < minRegularGaps {
+		return 0, ""
+	}
+	if len(comments)-1 > maxRegularGaps {
+		comments = comments[len(comments)-1-maxRegularGaps:]
+	}
+
+	gaps := make([]float64, 0, len(comments)-1)
+	var sum float64
+	for i := 1; i < len(comments); i++ {
+		gap := comments[i].CreatedTime.Sub(comments[i-1].CreatedTime).Seconds()
+		gaps = append(gaps, gap)
+		sum += gap
+	}
+	mean := sum / float64(len(gaps))
+	if mean <= 0 {
+		return 0, ""
+	}
+
+	var variance float64
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	cv := math.Sqrt(variance/float64(len(gaps))) / mean
+
+	if cv >= t.regularCV {
+		return 0, ""
+	}
+	return 1 - cv/t.regularCV,
+		fmt.Sprintf("%d gaps of %s on average, coefficient of variation %1.3f", len(gaps), time.Duration(mean*float64(time.Second)), cv)
+}
+
+// Longest stretch of hours of the day (UTC) without any comment.
+//  People sleep, so anything below min_idle_hours is suspicious.
+func (t *TimingDetector) clockScore(comments []db.UserComment) (float64, string) {
+	first, last := comments[0].CreatedTime, comments[len(comments)-1].CreatedTime
+	if last.Sub(first) < minClockDays*24*time.Hour {
+		return 0, ""
+	}
+
+	var active [24]bool
+	for _, c := range comments {
+		active[c.CreatedTime.UTC().Hour()] = true
+	}
+
+	// Longest run of idle hours, wrapping around midnight
+	idle, run := 0, 0
+	for h := 0; h < 48; h++ {
+		if active[h%24] {
+			run = 0
+			continue
+		}
+		run++
+		if run > idle {
+			idle = run
+		}
+	}
+	if idle > 24 {
+		idle = 24
+	}
+
+	if idle >= t.minIdleHours {
+		return 0, ""
+	}
+	return 1 - float64(idle)/float64(t.minIdleHours),
+		fmt.Sprintf("longest daily pause %dh over %s", idle, last.Sub(first))
+}
+
+// History a comment is judged by: window on both sides of when it was
+//  posted, so backfilled and delayed comments are compared with what
+//  the author did around that time rather than lately
+func around(entry *fbbot.FBComment, window time.Duration) (time.Time, time.Time) {
+	posted := entry.CreatedTime.Time
+	if posted.IsZero() {
+		posted = time.Now()
+	}
+	return posted.Add(-window), posted.Add(window)
+}
+
+// Settings left at 0 fall back to a default
+func orInt(value int, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func orFloat(value float64, fallback float64) float64 {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,221 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2018, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return start.Add(time.Duration(seconds) * time.Second)
+}
+
+// Comments of one author on the given posts, every seconds apart
+func every(seconds int, posts ...string) []db.UserComment {
+	comments := make([]db.UserComment, 0, len(posts))
+	for i, post := range posts {
+		comments = append(comments, db.UserComment{PostID: post, CreatedTime: at(i * seconds)})
+	}
+	return comments
+}
+
+func repeat(post string, n int) []string {
+	posts := make([]string, n)
+	for i := range posts {
+		posts[i] = post
+	}
+	return posts
+}
+
+// In-memory store with page1 and its posts p1 to p9, holding comments
+func memoryStore(t *testing.T, comments ...*fbbot.FBComment) *db.Memory {
+	m := db.NewMemory(&config.DBConfig{Pages: []string{"page1"}})
+	if err := m.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 9; i++ {
+		post := &fbbot.FBPost{ID: fmt.Sprintf("page1_p%d", i), CreatedTime: fbbot.NewGraphTime(start)}
+		if err := m.InsertPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range comments {
+		if _, err := m.UpsertComment(c, c.PostID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func timingDetector(store db.Store) *TimingDetector {
+	return &TimingDetector{
+		appdb:         store,
+		window:        defaultTimingWindowHours * time.Hour,
+		burst:         defaultBurstSeconds * time.Second,
+		burstComments: defaultBurstComments,
+		regularCV:     defaultRegularCV,
+		minIdleHours:  defaultMinIdleHours,
+	}
+}
+
+func TestBurstScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		comments []db.UserComment
+		want     float64
+	}{
+		{"too few", every(10, "p1", "p2", "p3", "p4"), 0},
+		{"at burst_comments", every(10, "p1", "p2", "p3", "p4", "p5"), 0.5},
+		{"twice burst_comments", every(5, "p1", "p2", "p3", "p4", "p5", "p1", "p2", "p3", "p4", "p5"), 1},
+		{"capped at 1", every(2, "p1", "p2", "p3", "p4", "p5", "p1", "p2", "p3", "p4", "p5", "p1", "p2", "p3", "p4", "p5"), 1},
+		{"all on one post", every(5, repeat("p1", 10)...), 0},
+		{"too slow", every(30, "p1", "p2", "p3", "p4", "p5"), 0},
+	}
+
+	d := timingDetector(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.burstScore(tt.comments); got != tt.want {
+				t.Errorf("burstScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegularScore(t *testing.T) {
+	// Gaps of 10 minutes give or take jitter seconds, from seconds
+	//  after start
+	jittered := func(from int, n int, jitter int) []db.UserComment {
+		comments := make([]db.UserComment, n)
+		for i := range comments {
+			offset := jitter
+			if i%2 == 0 {
+				offset = -jitter
+			}
+			comments[i] = db.UserComment{PostID: "p1", CreatedTime: at(from + i*600 + offset)}
+		}
+		return comments
+	}
+
+	// Bursts of replies with long pauses in between
+	human := make([]db.UserComment, 0)
+	for i := 0; i < 20; i++ {
+		human = append(human, db.UserComment{PostID: "p1", CreatedTime: at((i/5)*36000 + (i%5)*30)})
+	}
+
+	tests := []struct {
+		name     string
+		comments []db.UserComment
+		min, max float64
+	}{
+		{"too few gaps", jittered(0, 10, 0), 0, 0},
+		{"like a timer", jittered(0, 11, 0), 1, 1},
+		{"only the recent gaps count", append(human, jittered(200000, maxRegularGaps+1, 0)...), 1, 1},
+		{"slight jitter", jittered(0, 20, 30), 0.6, 0.7},
+		{"people are bursty", human, 0, 0},
+	}
+
+	d := timingDetector(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.regularScore(tt.comments); got < tt.min || got > tt.max {
+				t.Errorf("regularScore() = %v, want %v to %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestClockScore(t *testing.T) {
+	// A comment every step hours over days days, skipping the hours
+	//  from quiet on to the next morning
+	active := func(days int, step int, quiet int) []db.UserComment {
+		comments := make([]db.UserComment, 0)
+		for h := 0; h <= days*24; h += step {
+			if h%24 >= quiet {
+				continue
+			}
+			comments = append(comments, db.UserComment{PostID: "p1", CreatedTime: start.Truncate(24 * time.Hour).Add(time.Duration(h) * time.Hour)})
+		}
+		return comments
+	}
+
+	tests := []struct {
+		name     string
+		comments []db.UserComment
+		want     float64
+	}{
+		{"around the clock", active(4, 1, 24), 1},
+		{"one idle hour at a time", active(4, 2, 24), 0.75},
+		{"sleeps at night", active(4, 1, 16), 0},
+		{"too short to tell", active(2, 1, 24), 0},
+	}
+
+	d := timingDetector(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := d.clockScore(tt.comments); got != tt.want {
+				t.Errorf("clockScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAround(t *testing.T) {
+	entry := &fbbot.FBComment{CreatedTime: fbbot.NewGraphTime(start)}
+	since, until := around(entry, time.Hour)
+	if !since.Equal(at(-3600)) || !until.Equal(at(3600)) {
+		t.Errorf("around() = %s to %s, want an hour either side of %s", since, until, start)
+	}
+
+	since, until = around(&fbbot.FBComment{}, time.Hour)
+	if math.Abs(time.Since(since).Hours()-1) > 0.01 || math.Abs(time.Until(until).Hours()-1) > 0.01 {
+		t.Errorf("around() without a creation time = %s to %s, want an hour either side of now", since, until)
+	}
+}
+
+func TestTimingDetectWindow(t *testing.T) {
+	// A burst on several posts at start
+	comments := make([]*fbbot.FBComment, 0)
+	for i := 0; i < 2*defaultBurstComments; i++ {
+		c := &fbbot.FBComment{
+			ID:          fmt.Sprintf("c%d", i),
+			PostID:      fmt.Sprintf("p%d", i%3+1),
+			CreatedTime: fbbot.NewGraphTime(at(i * 5)),
+		}
+		c.From.ID = "bot"
+		comments = append(comments, c)
+	}
+	d := timingDetector(memoryStore(t, comments...))
+
+	tests := []struct {
+		name    string
+		created time.Time
+		want    bool
+	}{
+		{"during the burst", at(0), true},
+		{"a day later", at(24 * 3600), true},
+		{"long after", at(8 * 24 * 3600), false},
+		{"long before", at(-8 * 24 * 3600), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &fbbot.FBComment{ID: "new", CreatedTime: fbbot.NewGraphTime(tt.created)}
+			entry.From.ID = "bot"
+			signal, err := d.Detect(context.Background(), entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (signal != nil) != tt.want {
+				t.Errorf("Detect() = %+v, want a signal: %v", signal, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+/*
+Lists the campaigns of near-duplicate comments the classifier
+ found, e.g. "campaign 12: 310 comments posted by 140 accounts
+ across 12 pages in 2h0m0s"
+*/
+import (
+	"flag"
+	"fmt"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/logging"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var (
+	configFile string
+	logLevel   string
+	jsonLogs   bool
+	sinceHours int
+	minAuthors int
+	verbose    bool
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "/etc/fbbotscan.toml", "Path to TOML configuration file")
+	flag.StringVar(&logLevel, "l", "error", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&jsonLogs, "j", false, "Log as JSON, one object per line")
+	flag.IntVar(&sinceHours, "s", 24, "Only list campaigns active in the last this many hours")
+	flag.IntVar(&minAuthors, "a", 2, "Only list campaigns with at least this many authors")
+	flag.BoolVar(&verbose, "v", false, "List the comments of each campaign")
+}
+
+func main() {
+	flag.Parse()
+	logging.Setup(logLevel, jsonLogs)
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	appdb, err := db.NewStore(cfg.DB)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := appdb.Connect(); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	since := time.Now().Add(-time.Duration(sinceHours) * time.Hour)
+	campaigns, err := appdb.GetCampaigns(since, minAuthors)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	for _, campaign := range campaigns {
+		fmt.Printf("%s\n", &campaign)
+		fmt.Printf("  first seen: %s / last seen: %s\n", campaign.FirstSeen, campaign.LastSeen)
+		fmt.Printf("  %q\n", strings.TrimSpace(campaign.Message))
+
+		if !verbose {
+			continue
+		}
+
+		members, err := appdb.GetCampaignMembers(campaign.ID)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		for _, member := range members {
+			fmt.Printf("    %s  comment %s by %s on page %s (similarity %1.3f)\n",
+				member.CreatedTime,
+				member.CommentID,
+				member.UserID,
+				member.PageID,
+				member.Similarity,
+			)
+		}
+	}
+}
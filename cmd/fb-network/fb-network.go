@@ -0,0 +1,131 @@
+package main
+
+/*
+The network job builds a graph of authors commenting on the same
+ posts at the same time or posting near-identical text, finds
+ dense communities in it and stores them as coordinated networks.
+ It runs once (e.g. from cron) unless -d is given. With -u it
+ only looks up the network of an author.
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/network"
+	"github.com/moensch/fbbotscan/shutdown"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var (
+	configFile string
+	logLevel   string
+	jsonLogs   bool
+	daemon     bool
+	lookupUser string
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "/etc/fbbotscan.toml", "Path to TOML configuration file")
+	flag.StringVar(&logLevel, "l", "error", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&jsonLogs, "j", false, "Log as JSON, one object per line")
+	flag.BoolVar(&daemon, "d", false, "Keep running, every network.interval_minutes")
+	flag.StringVar(&lookupUser, "u", "", "Show the network of this user ID and exit")
+}
+
+func main() {
+	flag.Parse()
+	logging.Setup(logLevel, jsonLogs)
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	appdb, err := db.NewStore(cfg.DB)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := appdb.Connect(); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if lookupUser != "" {
+		n, err := appdb.GetUserNetwork(lookupUser)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		if n == nil {
+			fmt.Printf("%s is not part of any network\n", lookupUser)
+			return
+		}
+		fmt.Printf("network %d: %d authors, density %1.3f, weight %1.1f (found %s)\n", n.ID, len(n.Members), n.Density, n.Weight, n.Created)
+		fmt.Printf("  %s\n", strings.Join(n.Members, ", "))
+		return
+	}
+
+	stop := context.Background()
+	if daemon {
+		stop = shutdown.OnSignal()
+	}
+	for {
+		if err := analyze(appdb, cfg.Network); err != nil {
+			if !daemon {
+				log.Fatalf("Network analysis failed: %s", err)
+			}
+			log.Errorf("Network analysis failed: %s", err)
+		}
+
+		if !daemon {
+			break
+		}
+		if !shutdown.Sleep(stop, time.Duration(cfg.Network.IntervalMinutes)*time.Minute) {
+			break
+		}
+	}
+}
+
+func analyze(appdb db.Store, cfg *config.NetworkConfig) error {
+	since := time.Now().Add(-time.Duration(cfg.WindowHours) * time.Hour)
+	graph := network.NewGraph()
+
+	comments, err := appdb.GetCommentsSince(since)
+	if err != nil {
+		return err
+	}
+	graph.AddCoComments(comments, time.Duration(cfg.CoCommentSecs)*time.Second)
+	log.Infof("Linked authors of %d comments since %s", len(comments), since)
+
+	if cfg.CampaignWeight > 0 {
+		campaigns, err := appdb.GetCampaigns(since, 2)
+		if err != nil {
+			return err
+		}
+		for _, campaign := range campaigns {
+			members, err := appdb.GetCampaignMembers(campaign.ID)
+			if err != nil {
+				return err
+			}
+			graph.AddCampaign(members, cfg.CampaignWeight)
+		}
+		log.Infof("Linked authors of %d campaigns", len(campaigns))
+	}
+
+	graph.Prune(cfg.MinWeight)
+	networks := graph.Communities(cfg.MinSize, cfg.MinDensity)
+	log.Infof("Found %d networks among %d linked authors", len(networks), graph.Len())
+
+	if err := appdb.ReplaceNetworks(networks); err != nil {
+		return err
+	}
+
+	for _, n := range networks {
+		log.Infof("Network of %d authors, density %1.3f, weight %1.1f", len(n.Members), n.Density, n.Weight)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+/*
+The reindexer rebuilds comment indices created with an older
+ version of the comment mapping. Stop all storers first, the
+ indices are deleted and recreated while it runs.
+*/
+import (
+	"context"
+	"flag"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	configFile string
+	logLevel   string
+	jsonLogs   bool
+	dryRun     bool
+	indexName  string
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "/etc/fbbotscan.toml", "Path to TOML configuration file")
+	flag.StringVar(&logLevel, "l", "info", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&jsonLogs, "j", false, "Log as JSON, one object per line")
+	flag.BoolVar(&dryRun, "n", false, "Only list indices which need reindexing")
+	flag.StringVar(&indexName, "index", "", "Reindex only this index (regardless of its mapping version)")
+}
+
+func main() {
+	flag.Parse()
+	logging.Setup(logLevel, jsonLogs)
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	client := es.New(cfg.ES)
+	ctx := context.Background()
+
+	// Knows the pages of posts older documents were stored without
+	appdb, err := db.NewStore(cfg.DB)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := appdb.Connect(); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	// New indices must pick up the current mapping
+	if !dryRun {
+		if err := client.PutCommentTemplate(ctx); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	indices := []string{indexName}
+	if indexName == "" {
+		indices, err = client.OutdatedCommentIndices(ctx)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	if len(indices) == 0 {
+		log.Infof("All comment indices are at mapping version %d", fbbot.CommentMappingVersion)
+		return
+	}
+
+	for _, name := range indices {
+		if dryRun {
+			log.Infof("Would reindex %s", name)
+			continue
+		}
+
+		log.Warnf("Reindexing %s - make sure no storer is running", name)
+		if err := client.ReindexCommentIndex(ctx, name, appdb.GetPostPage); err != nil {
+			log.Fatalf("%s", err)
+		}
+		log.Infof("Reindexed %s to mapping version %d", name, fbbot.CommentMappingVersion)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+/*
+The retention job closes or deletes daily comment indices
+ older than the configured retention_days. It runs once
+ (e.g. from cron) unless an interval is given.
+*/
+import (
+	"context"
+	"flag"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/shutdown"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	configFile string
+	logLevel   string
+	jsonLogs   bool
+	interval   int
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "/etc/fbbotscan.toml", "Path to TOML configuration file")
+	flag.StringVar(&logLevel, "l", "error", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&jsonLogs, "j", false, "Log as JSON, one object per line")
+	flag.IntVar(&interval, "i", 0, "Run every this many seconds (0 runs once)")
+}
+
+func main() {
+	flag.Parse()
+	logging.Setup(logLevel, jsonLogs)
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if cfg.ES.RetentionDays <= 0 {
+		log.Fatalf("No retention configured (es.retention_days)")
+	}
+	maxAge := time.Duration(cfg.ES.RetentionDays) * 24 * time.Hour
+
+	client := es.New(cfg.ES)
+	ctx := context.Background()
+	stop := ctx
+	if interval > 0 {
+		stop = shutdown.OnSignal()
+	}
+
+	for {
+		log.Infof("Expiring comment indices older than %d days (%s)", cfg.ES.RetentionDays, cfg.ES.RetentionAction)
+		expired, err := client.ExpireCommentIndices(ctx, maxAge, cfg.ES.RetentionAction)
+		if err != nil {
+			if interval <= 0 {
+				log.Fatalf("Retention failed: %s", err)
+			}
+			log.Errorf("Retention failed: %s", err)
+		}
+		log.Infof("Expired %d indices", len(expired))
+
+		if interval <= 0 {
+			break
+		}
+		if !shutdown.Sleep(stop, time.Duration(interval)*time.Second) {
+			break
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+/*
+fbbotscan runs the pipeline stages and bundles maintenance subcommands:
+
+	fbbotscan [-f config] scheduler|storer|classifier
+	fbbotscan [-f config] [-t comments|posts] fetcher
+	fbbotscan [-f config] [-b memory|amqp] all
+	fbbotscan [-f config] config check
+
+ The stage subcommands do what fb-scheduler, fb-fetcher, fb-storer
+ and fb-classifier do. "all" runs every stage in one process, passing
+ messages through an in-memory broker unless -b amqp is given; with
+ the in-memory broker, messages still queued on exit are lost and
+ rescheduled once their objects are due again.
+
+ "config check" loads the configuration including FBBOTSCAN_*
+ environment overrides, reports every problem with it and prints
+ the effective settings with secrets redacted.
+*/
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/pipeline"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/shutdown"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+var (
+	configFile  string
+	logLevel    string
+	jsonLogs    bool
+	metricsAddr string
+	fetchType   string
+	brokerType  string
+)
+
+func init() {
+	flag.StringVar(&configFile, "f", "/etc/fbbotscan.toml", "Path to TOML configuration file")
+	flag.StringVar(&logLevel, "l", "warn", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&jsonLogs, "j", false, "Log as JSON, one object per line")
+	flag.StringVar(&metricsAddr, "m", "", "Serve Prometheus metrics and health checks on this address, e.g. :9100")
+	flag.StringVar(&fetchType, "t", "comments", "Fetch type for fetcher (comments|posts)")
+	flag.StringVar(&brokerType, "b", "memory", "Broker for all (memory|amqp)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] scheduler|fetcher|storer|classifier|all|config check\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	logging.Setup(logLevel, jsonLogs)
+
+	switch strings.Join(flag.Args(), " ") {
+	case "scheduler":
+		run("fb-scheduler", "amqp", "scheduler")
+	case "fetcher":
+		run("fb-fetcher", "amqp", fmt.Sprintf("%s-fetch", fetchType))
+	case "storer":
+		run("fb-storer", "amqp", "comments-store")
+	case "classifier":
+		run("fb-classifier", "amqp", "comments-classify")
+	case "all":
+		run("fbbotscan", brokerType, pipeline.Stages...)
+	case "config check":
+		configCheck()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// Run stages until SIGINT/SIGTERM
+func run(service string, broker string, stages ...string) {
+	metrics.Serve(metricsAddr)
+	ctx := shutdown.OnSignal()
+
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if err := tracing.Setup(cfg.Tracing, service); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	var b pubsub.Broker
+	switch broker {
+	case "memory":
+		log.Warnf("Using the in-memory broker, queued messages are lost on exit")
+		b = pubsub.NewMemoryBroker()
+	case "amqp":
+		b = pubsub.NewAMQPBroker(cfg.AMQP)
+	default:
+		log.Fatalf("Unknown broker %s", broker)
+	}
+	env, err := pipeline.NewEnv(cfg, b)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if err := pipeline.Run(ctx, env, stages...); err != nil {
+		log.Fatalf("%s", err)
+	}
+	tracing.Shutdown()
+}
+
+func configCheck() {
+	cfg, err := config.LoadFile(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.FB.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s (needed by fb-fetcher)\n", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Configuration %s is valid, effective settings:\n", configFile)
+	if err := toml.NewEncoder(os.Stdout).Encode(cfg.Redacted()); err != nil {
+		log.Fatalf("Cannot print configuration: %s", err)
+	}
+}
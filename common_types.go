@@ -1,7 +1,108 @@
 package fbbotscan
 
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timestamp format used by the Graph API
+const GraphTimeFormat = "2006-01-02T15:04:05-0700"
+
 type QueueEntry struct {
-	ObjectID    string `json:"object_id"`
-	LastChecked int64  `json:"last_checked"`
-	ObjectType  string `json:"type"`
+	ObjectID    string    `json:"object_id"`
+	LastChecked GraphTime `json:"last_checked"`
+	ObjectType  string    `json:"type"`
+	Full        bool      `json:"full"`
+}
+
+func ParseGraphTime(value string) (time.Time, error) {
+	return time.Parse(GraphTimeFormat, value)
+}
+
+// A timestamp which marshals to the Graph API format in JSON and to
+//  a timestamp (or NULL) in SQL. The zero value means "never"/unknown.
+type GraphTime struct {
+	time.Time
+}
+
+func NewGraphTime(t time.Time) GraphTime {
+	return GraphTime{Time: t}
+}
+
+func (t GraphTime) String() string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(GraphTimeFormat)
+}
+
+func (t GraphTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Quote(t.Format(GraphTimeFormat))), nil
+}
+
+// Accepts Graph API timestamps, null and empty strings. Unix
+//  timestamps are accepted for queue entries published before
+//  last_checked was typed, with 1 meaning "never".
+func (t *GraphTime) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" || value == `""` {
+		*t = GraphTime{}
+		return nil
+	}
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*t = GraphTime{}
+		if unix > 1 {
+			t.Time = time.Unix(unix, 0)
+		}
+		return nil
+	}
+
+	value, err := strconv.Unquote(value)
+	if err != nil {
+		return fmt.Errorf("Invalid Graph API time %s: %s", data, err)
+	}
+
+	parsed, err := ParseGraphTime(value)
+	if err != nil {
+		return fmt.Errorf("Invalid Graph API time %s: %s", data, err)
+	}
+	t.Time = parsed
+
+	return nil
+}
+
+func (t GraphTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.Time, nil
+}
+
+func (t *GraphTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = GraphTime{}
+	case time.Time:
+		t.Time = v
+	case int64:
+		// Unix timestamps, e.g. EXTRACT(EPOCH FROM ...)
+		t.Time = time.Unix(v, 0)
+	default:
+		return fmt.Errorf("Cannot scan %T into GraphTime", src)
+	}
+	return nil
+}
+
+// The "since" parameter for Graph API calls, 1 if never checked
+func (t GraphTime) Since() int64 {
+	if t.IsZero() {
+		return 1
+	}
+	return t.Unix()
 }
@@ -0,0 +1,131 @@
+package fbbotscan
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var checked = time.Date(2018, 3, 4, 15, 30, 0, 0, time.FixedZone("", 3600))
+
+func TestGraphTimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    GraphTime
+		wantErr bool
+	}{
+		{name: "graph api time", json: `"2018-03-04T15:30:00+0100"`, want: NewGraphTime(checked)},
+		{name: "null", json: `null`},
+		{name: "empty string", json: `""`},
+		{name: "unix timestamp", json: `1520173800`, want: NewGraphTime(checked)},
+		{name: "never as unix timestamp", json: `1`},
+		{name: "other format", json: `"2018-03-04 15:30:00"`, wantErr: true},
+		{name: "not a string", json: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got GraphTime
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want.Time) {
+				t.Errorf("Unmarshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphTimeJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		time GraphTime
+		want string
+	}{
+		{"time", NewGraphTime(checked), `"2018-03-04T15:30:00+0100"`},
+		{"never", GraphTime{}, `null`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := QueueEntry{ObjectID: "1_2", LastChecked: tt.time, ObjectType: "post"}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(fields["last_checked"]); got != tt.want {
+				t.Errorf("last_checked = %s, want %s", got, tt.want)
+			}
+
+			var got QueueEntry
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.LastChecked.Equal(tt.time.Time) {
+				t.Errorf("round trip = %s, want %s", got.LastChecked, tt.time)
+			}
+		})
+	}
+}
+
+func TestGraphTimeSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       interface{}
+		want      GraphTime
+		wantValue driver.Value
+		wantErr   bool
+	}{
+		{name: "timestamp", src: checked, want: NewGraphTime(checked), wantValue: checked},
+		{name: "null", src: nil},
+		{name: "unix timestamp", src: int64(1520173800), want: NewGraphTime(checked), wantValue: checked},
+		{name: "string", src: "2018-03-04T15:30:00+0100", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewGraphTime(time.Now())
+			err := got.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(tt.want.Time) {
+				t.Errorf("Scan() = %s, want %s", got, tt.want)
+			}
+
+			value, err := got.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantValue == nil {
+				if value != nil {
+					t.Errorf("Value() = %v, want NULL", value)
+				}
+				return
+			}
+			if v, ok := value.(time.Time); !ok || !v.Equal(tt.wantValue.(time.Time)) {
+				t.Errorf("Value() = %v, want %v", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestGraphTimeSince(t *testing.T) {
+	if since := (GraphTime{}).Since(); since != 1 {
+		t.Errorf("Since() of the zero time = %d, want 1", since)
+	}
+	if since := NewGraphTime(checked).Since(); since != 1520173800 {
+		t.Errorf("Since() = %d, want 1520173800", since)
+	}
+}
@@ -3,48 +3,139 @@ package config
 import (
 	"fmt"
 	"github.com/BurntSushi/toml"
-	log "github.com/Sirupsen/logrus"
+	"github.com/moensch/fbbotscan/secrets"
+	log "github.com/sirupsen/logrus"
 	"os"
 )
 
 type Config struct {
-	FB       *FBConfig
-	AMQP     *AMQPConfig
-	DB       *DBConfig
-	ES       *ESConfig
-	Classify *ClassifyConfig
+	FB        *FBConfig                  `toml:"fb"`
+	AMQP      *AMQPConfig                `toml:"amqp"`
+	DB        *DBConfig                  `toml:"db"`
+	ES        *ESConfig                  `toml:"es"`
+	Classify  *ClassifyConfig            `toml:"classify"`
+	Network   *NetworkConfig             `toml:"network"`
+	Tracing   *TracingConfig             `toml:"tracing"`
+	Consumers map[string]*ConsumerConfig `toml:"consumers"`
 }
 
 type FBConfig struct {
 	AppID                string `toml:"app_id"`
 	AppSecret            string `toml:"app_secret"`
+	AppSecretFile        string `toml:"app_secret_file"`
+	GraphDebug           string `toml:"graph_debug"`
 	FeedCheckSeconds     int    `toml:"feed_check_seconds"`
 	CommentsCheckSeconds int    `toml:"comments_check_seconds"`
+	FullCheckSeconds     int    `toml:"full_check_seconds"`
 }
 
 type AMQPConfig struct {
-	URI string `toml:"uri"`
+	URI             string `toml:"uri"`
+	URIFile         string `toml:"uri_file"`
+	ShutdownSeconds int    `toml:"shutdown_seconds"`
+}
+
+// Settings of the consumer of one queue
+type ConsumerConfig struct {
+	Workers      int `toml:"workers"`
+	Prefetch     int `toml:"prefetch"`
+	Retries      int `toml:"retries"`
+	RetrySeconds int `toml:"retry_seconds"`
 }
 
 type DBConfig struct {
-	Type    string `toml:"type"`
-	Connstr string `toml:"connstr"`
+	Type        string   `toml:"type"`
+	Connstr     string   `toml:"connstr"`
+	ConnstrFile string   `toml:"connstr_file"`
+	Pages       []string `toml:"pages"`
 }
 
 type ESConfig struct {
-	URL string `toml:"url"`
+	URL              string `toml:"url"`
+	BulkActions      int    `toml:"bulk_actions"`
+	BulkSize         int    `toml:"bulk_size"`
+	BulkFlushSeconds int    `toml:"bulk_flush_seconds"`
+	BulkWorkers      int    `toml:"bulk_workers"`
+	Shards           int    `toml:"shards"`
+	Replicas         int    `toml:"replicas"`
+	RetentionDays    int    `toml:"retention_days"`
+	RetentionAction  string `toml:"retention_action"`
 }
 
 type ClassifyConfig struct {
-	DiscardScore float64 `toml:"discard_score"`
-	MatchScore   float64 `toml:"match_score"`
+	Detectors          []string           `toml:"detectors"`
+	Weights            map[string]float64 `toml:"weights"`
+	SuspectThreshold   float64            `toml:"suspect_threshold"`
+	MatchThreshold     float64            `toml:"match_threshold"`
+	DiscardScore       float64            `toml:"discard_score"`
+	MatchScore         float64            `toml:"match_score"`
+	LookbackDays       int                `toml:"lookback_days"`
+	DiscardSimilarity  float64            `toml:"discard_similarity"`
+	MatchSimilarity    float64            `toml:"match_similarity"`
+	MinhashDocuments   int                `toml:"minhash_documents"`
+	CampaignSimilarity float64            `toml:"campaign_similarity"`
+	TimingWindowHours  int                `toml:"timing_window_hours"`
+	BurstSeconds       int                `toml:"burst_seconds"`
+	BurstComments      int                `toml:"burst_comments"`
+	RegularCV          float64            `toml:"regular_cv"`
+	MinIdleHours       int                `toml:"min_idle_hours"`
+	ReplyWindowHours   int                `toml:"reply_window_hours"`
+	MinReplies         int                `toml:"min_replies"`
+	TargetShare        float64            `toml:"target_share"`
+	PileOnSeconds      int                `toml:"pile_on_seconds"`
+	PileOnAuthors      int                `toml:"pile_on_authors"`
+	SelfReplies        int                `toml:"self_replies"`
+}
+
+type NetworkConfig struct {
+	WindowHours     int     `toml:"window_hours"`
+	CoCommentSecs   int     `toml:"co_comment_seconds"`
+	CampaignWeight  float64 `toml:"campaign_weight"`
+	MinWeight       float64 `toml:"min_weight"`
+	MinSize         int     `toml:"min_size"`
+	MinDensity      float64 `toml:"min_density"`
+	IntervalMinutes int     `toml:"interval_minutes"`
 }
 
-func (c *Config) Valid() bool {
-	// TODO
-	return true
+// OpenTelemetry tracing, off unless an exporter is set
+type TracingConfig struct {
+	// otlp, stdout or file
+	Exporter    string  `toml:"exporter"`
+	Endpoint    string  `toml:"endpoint"`
+	Insecure    bool    `toml:"insecure"`
+	File        string  `toml:"file"`
+	SampleRatio float64 `toml:"sample_ratio"`
 }
 
+// Settings of the consumer of a queue. One worker with a prefetch
+//  of ten deliveries per worker and two retries, one and two seconds
+//  apart, unless [consumers.<queue>] says otherwise. Retries of -1
+//  turns them off.
+func (c *Config) Consumer(queue string) ConsumerConfig {
+	var cc ConsumerConfig
+	if c.Consumers[queue] != nil {
+		cc = *c.Consumers[queue]
+	}
+	if cc.Workers < 1 {
+		cc.Workers = 1
+	}
+	if cc.Prefetch < 1 {
+		cc.Prefetch = 10 * cc.Workers
+	}
+	if cc.Retries == 0 {
+		cc.Retries = 2
+	} else if cc.Retries < 0 {
+		cc.Retries = 0
+	}
+	if cc.RetrySeconds < 1 {
+		cc.RetrySeconds = 1
+	}
+	return cc
+}
+
+// Load a configuration file, apply FBBOTSCAN_* environment overrides,
+//  defaults and *_file secrets and validate the result. Secrets are
+//  redacted from all log output from then on.
 func LoadFile(path string) (*Config, error) {
 	log.Debugf("loading configuration file from %s", path)
 	if path == "" {
@@ -64,8 +155,16 @@ func LoadFile(path string) (*Config, error) {
 
 	log.Debugf("Successfully loaded config %s", path)
 
-	if !cfg.Valid() {
-		return nil, fmt.Errorf("Invalid configuration")
+	cfg.setDefaults()
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	secrets.Register(cfg.secrets()...)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 	return cfg, nil
 }
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Configuration with defaults which passes validation
+func validConfig() *Config {
+	c := &Config{DB: &DBConfig{Connstr: "host=localhost dbname=fbbotscan"}}
+	c.setDefaults()
+	return c
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		check   func(c *Config) interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name:    "string",
+			environ: []string{"FBBOTSCAN_FB_APP_SECRET=s3cr3t-value"},
+			check:   func(c *Config) interface{} { return c.FB.AppSecret },
+			want:    "s3cr3t-value",
+		},
+		{
+			name:    "int",
+			environ: []string{"FBBOTSCAN_ES_BULK_WORKERS=4"},
+			check:   func(c *Config) interface{} { return c.ES.BulkWorkers },
+			want:    4,
+		},
+		{
+			name:    "float",
+			environ: []string{"FBBOTSCAN_CLASSIFY_MATCH_THRESHOLD=0.9"},
+			check:   func(c *Config) interface{} { return c.Classify.MatchThreshold },
+			want:    0.9,
+		},
+		{
+			name:    "bool",
+			environ: []string{"FBBOTSCAN_TRACING_INSECURE=true"},
+			check:   func(c *Config) interface{} { return c.Tracing.Insecure },
+			want:    true,
+		},
+		{
+			name:    "comma separated list",
+			environ: []string{"FBBOTSCAN_DB_PAGES=page1, page2,,"},
+			check:   func(c *Config) interface{} { return c.DB.Pages },
+			want:    []string{"page1", "page2"},
+		},
+		{
+			name:    "map of values",
+			environ: []string{"FBBOTSCAN_CLASSIFY_WEIGHTS_MLT=2", "FBBOTSCAN_CLASSIFY_WEIGHTS_MINHASH=0.5"},
+			check:   func(c *Config) interface{} { return c.Classify.Weights },
+			want:    map[string]float64{"mlt": 2, "minhash": 0.5},
+		},
+		{
+			name:    "map of sections",
+			environ: []string{"FBBOTSCAN_CONSUMERS_COMMENTS_FETCH_WORKERS=3", "FBBOTSCAN_CONSUMERS_COMMENTS_FETCH_RETRY_SECONDS=5"},
+			check:   func(c *Config) interface{} { return *c.Consumers["comments-fetch"] },
+			want:    ConsumerConfig{Workers: 3, RetrySeconds: 5},
+		},
+		{
+			name:    "other variables are ignored",
+			environ: []string{"FB_APP_SECRET=other", "FBBOTSCAN_FB_UNKNOWN=1"},
+			check:   func(c *Config) interface{} { return c.FB.AppSecret },
+			want:    "",
+		},
+		{
+			name:    "invalid number",
+			environ: []string{"FBBOTSCAN_ES_SHARDS=many"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			err := c.applyEnv(tt.environ)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := tt.check(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// Part of the error, "" if valid
+		want string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"memory database without connstr", func(c *Config) { c.DB = &DBConfig{Type: "memory"} }, ""},
+		{"postgres without connstr", func(c *Config) { c.DB.Connstr = "" }, "db.connstr is required"},
+		{"unknown database", func(c *Config) { c.DB.Type = "mysql" }, "not 'mysql'"},
+		{"amqp scheme", func(c *Config) { c.AMQP.URI = "http://localhost/" }, "amqp.uri must be"},
+		{"es url without host", func(c *Config) { c.ES.URL = "http://" }, "es.url must be"},
+		{"negative replicas", func(c *Config) { c.ES.Replicas = -1 }, "es.replicas must not be negative"},
+		{"duplicate detector", func(c *Config) { c.Classify.Detectors = []string{"mlt", "mlt"} }, "lists 'mlt' twice"},
+		{"thresholds swapped", func(c *Config) { c.Classify.SuspectThreshold = 0.9 }, "suspect_threshold must not be above"},
+		{"similarity out of range", func(c *Config) { c.Classify.MatchSimilarity = 1.5 }, "match_similarity must be between"},
+		{"file exporter without file", func(c *Config) { c.Tracing.Exporter = "file" }, "tracing.file is required"},
+		{"negative retries", func(c *Config) { c.Consumers["posts-fetch"] = &ConsumerConfig{Retries: -2} }, "consumers.posts-fetch.retries"},
+		{
+			name: "lists every problem",
+			modify: func(c *Config) {
+				c.ES.Shards = -1
+				c.Network.WindowHours = -1
+			},
+			want: "es.shards must be positive; network.window_hours must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	tests := []struct {
+		name     string
+		consumer *ConsumerConfig
+		want     ConsumerConfig
+	}{
+		{"defaults", nil, ConsumerConfig{Workers: 1, Prefetch: 10, Retries: 2, RetrySeconds: 1}},
+		{"prefetch per worker", &ConsumerConfig{Workers: 3}, ConsumerConfig{Workers: 3, Prefetch: 30, Retries: 2, RetrySeconds: 1}},
+		{"retries off", &ConsumerConfig{Retries: -1}, ConsumerConfig{Workers: 1, Prefetch: 10, Retries: 0, RetrySeconds: 1}},
+		{"as configured", &ConsumerConfig{Workers: 2, Prefetch: 5, Retries: 4, RetrySeconds: 10}, ConsumerConfig{Workers: 2, Prefetch: 5, Retries: 4, RetrySeconds: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			if tt.consumer != nil {
+				c.Consumers["q"] = tt.consumer
+			}
+			if got := c.Consumer("q"); got != tt.want {
+				t.Errorf("Consumer() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fbbotscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fbbotscan.toml")
+	data := "[db]\nconnstr = \"host=localhost\"\n\n[es]\nshards = 2\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("FBBOTSCAN_ES_SHARDS", "3")
+	c, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ES.Shards != 3 {
+		t.Errorf("es.shards = %d, want the override 3", c.ES.Shards)
+	}
+	if c.ES.BulkActions != 1000 {
+		t.Errorf("es.bulk_actions = %d, want the default 1000", c.ES.BulkActions)
+	}
+
+	t.Setenv("FBBOTSCAN_ES_SHARDS", "0")
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile() accepted es.shards = 0")
+	}
+}
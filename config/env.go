@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "FBBOTSCAN_"
+
+// Override settings from FBBOTSCAN_<SECTION>_<KEY> environment
+//  variables, e.g. FBBOTSCAN_FB_APP_SECRET, FBBOTSCAN_CLASSIFY_WEIGHTS_MLT
+//  or FBBOTSCAN_CONSUMERS_COMMENTS_FETCH_WORKERS. Lists are comma
+//  separated, dashes in map keys are written as underscores.
+func (c *Config) applyEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, envPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	if len(env) == 0 {
+		return nil
+	}
+
+	cfg := reflect.ValueOf(c).Elem()
+	for i := 0; i < cfg.NumField(); i++ {
+		name := envPrefix + strings.ToUpper(tomlName(cfg.Type().Field(i)))
+		if err := setFromEnv(cfg.Field(i), name, env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set v, or everything below it, from the variable called name
+func setFromEnv(v reflect.Value, name string, env map[string]string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		return setFromEnv(v.Elem(), name, env)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := name + "_" + strings.ToUpper(tomlName(v.Type().Field(i)))
+			if err := setFromEnv(v.Field(i), field, env); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		return setMapFromEnv(v, name, env)
+	}
+
+	value, ok := env[name]
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		var n int
+		n, err = strconv.Atoi(value)
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, 64)
+		v.SetFloat(f)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case reflect.Slice:
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		err = fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// Maps of values (classify.weights) take the key from the rest of
+//  the variable name, maps of sections (consumers) take it from the
+//  part before the setting
+func setMapFromEnv(v reflect.Value, name string, env map[string]string) error {
+	elem := v.Type().Elem()
+	for variable := range env {
+		if !strings.HasPrefix(variable, name+"_") {
+			continue
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		rest := strings.TrimPrefix(variable, name+"_")
+
+		if elem.Kind() != reflect.Ptr {
+			key := mapKey(rest)
+			value := reflect.New(elem).Elem()
+			if err := setFromEnv(value, variable, env); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key), value)
+			continue
+		}
+
+		for i := 0; i < elem.Elem().NumField(); i++ {
+			suffix := "_" + strings.ToUpper(tomlName(elem.Elem().Field(i)))
+			if !strings.HasSuffix(rest, suffix) {
+				continue
+			}
+
+			key := reflect.ValueOf(mapKey(strings.TrimSuffix(rest, suffix)))
+			section := v.MapIndex(key)
+			if !section.IsValid() || section.IsNil() {
+				section = reflect.New(elem.Elem())
+				v.SetMapIndex(key, section)
+			}
+			if err := setFromEnv(section.Elem().Field(i), variable, env); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func mapKey(s string) string {
+	return strings.Replace(strings.ToLower(s), "_", "-", -1)
+}
+
+// Name of a setting in the TOML file
+func tomlName(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("toml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(field.Name)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"github.com/moensch/fbbotscan/secrets"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// password=... in a postgres key/value connection string
+var connstrPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// Copy of the configuration with secrets and passwords replaced,
+//  safe to print or log
+func (c *Config) Redacted() *Config {
+	r := *c
+	if c.FB != nil {
+		fb := *c.FB
+		if fb.AppSecret != "" {
+			fb.AppSecret = secrets.Redacted
+		}
+		r.FB = &fb
+	}
+	if c.AMQP != nil {
+		amqp := *c.AMQP
+		amqp.URI = amqp.RedactedURI()
+		r.AMQP = &amqp
+	}
+	if c.DB != nil {
+		db := *c.DB
+		db.Connstr = db.RedactedConnstr()
+		r.DB = &db
+	}
+	if c.ES != nil {
+		es := *c.ES
+		es.URL = redactURL(es.URL)
+		r.ES = &es
+	}
+	return &r
+}
+
+// Connection string with the password replaced, for logging
+func (c *DBConfig) RedactedConnstr() string {
+	if strings.Contains(c.Connstr, "://") {
+		return redactURL(c.Connstr)
+	}
+	return connstrPassword.ReplaceAllString(c.Connstr, "${1}"+secrets.Redacted)
+}
+
+// AMQP URI with the password replaced, for logging
+func (c *AMQPConfig) RedactedURI() string {
+	return redactURL(c.URI)
+}
+
+// Secrets and passwords found in the configuration
+func (c *Config) secrets() []string {
+	values := []string{
+		c.FB.AppSecret,
+		urlPassword(c.AMQP.URI),
+		urlPassword(c.ES.URL),
+	}
+	if strings.Contains(c.DB.Connstr, "://") {
+		values = append(values, urlPassword(c.DB.Connstr))
+	} else if m := connstrPassword.FindStringSubmatch(c.DB.Connstr); m != nil {
+		values = append(values, m[2], strings.Trim(m[2], "'"))
+	}
+	return values
+}
+
+// Read settings from files (e.g. Docker or Kubernetes secrets) where
+//  a *_file setting is given. The file takes precedence over the
+//  setting itself.
+func (c *Config) readSecretFiles() error {
+	files := []struct {
+		name  string
+		path  string
+		value *string
+	}{
+		{"fb.app_secret_file", c.FB.AppSecretFile, &c.FB.AppSecret},
+		{"amqp.uri_file", c.AMQP.URIFile, &c.AMQP.URI},
+		{"db.connstr_file", c.DB.ConnstrFile, &c.DB.Connstr},
+	}
+
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return fmt.Errorf("Cannot read %s: %s", f.name, err)
+		}
+		*f.value = strings.TrimSpace(string(data))
+	}
+
+	return nil
+}
+
+// Password of a URL, if it has one
+func urlPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return ""
+	}
+	password, _ := u.User.Password()
+	return password
+}
+
+// Replace the password of a URL, if it has one
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), secrets.Redacted)
+	return u.String()
+}
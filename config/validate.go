@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Check the settings make sense, listing everything that doesn't
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.FB.FeedCheckSeconds > 0, "fb.feed_check_seconds must be positive")
+	check(c.FB.CommentsCheckSeconds > 0, "fb.comments_check_seconds must be positive")
+	check(c.FB.FullCheckSeconds >= 0, "fb.full_check_seconds must not be negative")
+	switch c.FB.GraphDebug {
+	case "", "info", "warning", "all":
+	default:
+		check(false, "fb.graph_debug must be info, warning or all, not '%s'", c.FB.GraphDebug)
+	}
+
+	check(validURL(c.AMQP.URI, "amqp", "amqps"), "amqp.uri must be an amqp:// or amqps:// URI")
+	check(c.AMQP.ShutdownSeconds > 0, "amqp.shutdown_seconds must be positive")
+
+	check(c.DB.Type == "postgres" || c.DB.Type == "memory", "db.type must be postgres or memory, not '%s'", c.DB.Type)
+	check(c.DB.Type != "postgres" || c.DB.Connstr != "", "db.connstr is required for postgres")
+
+	check(validURL(c.ES.URL, "http", "https"), "es.url must be an http:// or https:// URL")
+	check(c.ES.BulkActions > 0, "es.bulk_actions must be positive")
+	check(c.ES.BulkSize > 0, "es.bulk_size must be positive")
+	check(c.ES.BulkFlushSeconds > 0, "es.bulk_flush_seconds must be positive")
+	check(c.ES.BulkWorkers > 0, "es.bulk_workers must be positive")
+	check(c.ES.Shards > 0, "es.shards must be positive")
+	check(c.ES.Replicas >= 0, "es.replicas must not be negative")
+	check(c.ES.RetentionDays >= 0, "es.retention_days must not be negative")
+	check(c.ES.RetentionAction == "close" || c.ES.RetentionAction == "delete", "es.retention_action must be close or delete, not '%s'", c.ES.RetentionAction)
+
+	cl := c.Classify
+	seen := make(map[string]bool)
+	for _, name := range cl.Detectors {
+		check(name != "", "classify.detectors must not contain empty names")
+		check(!seen[name], "classify.detectors lists '%s' twice", name)
+		seen[name] = true
+	}
+	for name, w := range cl.Weights {
+		check(w >= 0, "classify.weights.%s must not be negative", name)
+	}
+	check(inRange(cl.SuspectThreshold), "classify.suspect_threshold must be between 0 and 1")
+	check(inRange(cl.MatchThreshold), "classify.match_threshold must be between 0 and 1")
+	check(cl.SuspectThreshold <= cl.MatchThreshold, "classify.suspect_threshold must not be above match_threshold")
+	check(cl.DiscardScore <= cl.MatchScore, "classify.discard_score must not be above match_score")
+	check(cl.LookbackDays >= 0, "classify.lookback_days must not be negative")
+	check(inRange(cl.DiscardSimilarity), "classify.discard_similarity must be between 0 and 1")
+	check(inRange(cl.MatchSimilarity), "classify.match_similarity must be between 0 and 1")
+	check(cl.DiscardSimilarity <= cl.MatchSimilarity, "classify.discard_similarity must not be above match_similarity")
+	check(inRange(cl.CampaignSimilarity), "classify.campaign_similarity must be between 0 and 1")
+	check(cl.MinhashDocuments >= 0, "classify.minhash_documents must not be negative")
+	check(cl.RegularCV >= 0, "classify.regular_cv must not be negative")
+	check(inRange(cl.TargetShare), "classify.target_share must be between 0 and 1")
+
+	check(c.Network.WindowHours > 0, "network.window_hours must be positive")
+	check(c.Network.CoCommentSecs > 0, "network.co_comment_seconds must be positive")
+	check(c.Network.CampaignWeight >= 0, "network.campaign_weight must not be negative")
+	check(inRange(c.Network.MinDensity), "network.min_density must be between 0 and 1")
+	check(c.Network.IntervalMinutes > 0, "network.interval_minutes must be positive")
+
+	switch c.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	case "file":
+		check(c.Tracing.File != "", "tracing.file is required for the file exporter")
+	default:
+		check(false, "tracing.exporter must be otlp, stdout or file, not '%s'", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio > 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be above 0 and at most 1")
+
+	for queue, cc := range c.Consumers {
+		check(cc.Workers >= 0, "consumers.%s.workers must not be negative", queue)
+		check(cc.Prefetch >= 0, "consumers.%s.prefetch must not be negative", queue)
+		check(cc.Retries >= -1, "consumers.%s.retries must be -1 or more", queue)
+		check(cc.RetrySeconds >= 0, "consumers.%s.retry_seconds must not be negative", queue)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Facebook app credentials, only needed by services calling the
+//  Graph API
+func (c *FBConfig) Validate() error {
+	if c.AppID == "" || c.AppSecret == "" {
+		return fmt.Errorf("Invalid configuration: fb.app_id and fb.app_secret are required")
+	}
+	return nil
+}
+
+func inRange(v float64) bool {
+	return v >= 0 && v <= 1
+}
+
+func validURL(s string, schemes ...string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,25 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	fbbot "github.com/moensch/fbbotscan"
 	"github.com/moensch/fbbotscan/config"
+	log "github.com/sirupsen/logrus"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type DB struct {
 	Conn   *sql.DB
 	Config *config.DBConfig
+
+	// Trace of the queries, see WithContext
+	ctx context.Context
 }
 
 func New(cfg *config.DBConfig) *DB {
@@ -28,7 +36,7 @@ func New(cfg *config.DBConfig) *DB {
 
 func (db *DB) Initialize() error {
 	log.Debugf("DB type: %s", db.Config.Type)
-	log.Debugf("DB Connection: %s", db.Config.Connstr)
+	log.Debugf("DB Connection: %s", db.Config.RedactedConnstr())
 	return nil
 }
 
@@ -45,73 +53,111 @@ func (db *DB) Connect() error {
 	return err
 }
 
+// Check the database is reachable, for health checks
+func (db *DB) Ping() error {
+	if db.Conn == nil {
+		return fmt.Errorf("Not connected to database")
+	}
+	if err := db.Conn.Ping(); err != nil {
+		return fmt.Errorf("Cannot reach database: %s", err)
+	}
+	return nil
+}
+
 // Get all post and comments which haven't been checked for new comments
 //  in over delaySecs seconds.
 //  Exclude comments which have a parent as sub-comments cannot
 //    have more comments
-func (db *DB) GetSchedulerComments(delaySecs int) (*sql.Rows, error) {
-	check_query := `SELECT id, objtype, last_check FROM (
-				SELECT concat(page_id, '_', post_id) AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'post' AS objtype FROM posts
+//  Objects which haven't had a full re-fetch in fullDelaySecs seconds
+//    (or never) are flagged as such, 0 disables periodic full re-fetches.
+func (db *DB) GetSchedulerComments(delaySecs int, fullDelaySecs int) ([]fbbot.QueueEntry, error) {
+	check_query := `SELECT id, objtype, last_check, full_check FROM (
+				SELECT concat(page_id, '_', post_id) AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'post' AS objtype, %[2]s AS full_check FROM posts
 					WHERE scheduled = 'f'
 				UNION
-				SELECT concat(post_id, '_', comment_id) AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'comment' AS objtype FROM comments
+				SELECT concat(post_id, '_', comment_id) AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'comment' AS objtype, %[2]s AS full_check FROM comments
 					WHERE (parent_id IS NULL OR parent_id = '')
 					AND scheduled = 'f'
+					AND deleted = 'f'
 			) sub1
-			WHERE last_check < extract(EPOCH FROM NOW()) - %d
+			WHERE last_check < extract(EPOCH FROM NOW()) - %[1]d
 			OR last_check IS NULL`
 
-	log.Debugf("Query: %s", fmt.Sprintf(check_query, delaySecs))
-	rows, err := db.Conn.Query(fmt.Sprintf(check_query, delaySecs))
-	if err != nil {
-		return nil, fmt.Errorf("Database query failed: %s", err)
+	full_check := "(last_full_check IS NULL)"
+	if fullDelaySecs > 0 {
+		full_check = fmt.Sprintf("(last_full_check IS NULL OR last_full_check < NOW() - interval '%d seconds')", fullDelaySecs)
 	}
 
-	return rows, err
+	return db.queryQueueEntries(fmt.Sprintf(check_query, delaySecs, full_check))
 }
 
 // Get all the pages which haven't been checked for new posts
 //  in over delaySecs seconds (or never)
-func (db *DB) GetSchedulerPosts(delaySecs int) (*sql.Rows, error) {
-	check_query := `SELECT id, objtype, last_check FROM (
-				SELECT page_id AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'page' as objtype FROM pages
+func (db *DB) GetSchedulerPosts(delaySecs int) ([]fbbot.QueueEntry, error) {
+	check_query := `SELECT id, objtype, last_check, full_check FROM (
+				SELECT page_id AS id, cast(EXTRACT(EPOCH FROM last_check) as integer) AS last_check, 'page' as objtype, false AS full_check FROM pages
 					WHERE scheduled = 'f'
 			) sub1
 			WHERE last_check < extract(EPOCH FROM NOW()) - %d
 			OR last_check IS NULL`
 
-	log.Debugf("Query: %s", fmt.Sprintf(check_query, delaySecs))
-	rows, err := db.Conn.Query(fmt.Sprintf(check_query, delaySecs))
+	return db.queryQueueEntries(fmt.Sprintf(check_query, delaySecs))
+}
+
+// Run a scheduler query and scan its (id, objtype, last_check, full_check) rows
+//  into queue entries
+func (db *DB) queryQueueEntries(query string) ([]fbbot.QueueEntry, error) {
+	log.Debugf("Query: %s", query)
+	rows, err := db.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("Database query failed: %s", err)
 	}
+	defer rows.Close()
+
+	entries := make([]fbbot.QueueEntry, 0)
+	for rows.Next() {
+		entry := fbbot.QueueEntry{}
+
+		if err := rows.Scan(&entry.ObjectID, &entry.ObjectType, &entry.LastChecked, &entry.Full); err != nil {
+			return entries, fmt.Errorf("Scan error: %s", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
 
-	return rows, err
+// Table and ID column of each object type. Only these are put into
+//  SQL, IDs are always passed as parameters.
+var entryTables = map[string][2]string{
+	"page":    {"pages", "page_id"},
+	"post":    {"posts", "post_id"},
+	"comment": {"comments", "comment_id"},
 }
 
-func (db *DB) UpdateLastCheck(entryType string, id string, last_check int64) error {
-	if entryType != "post" && entryType != "page" && entryType != "comment" {
-		return fmt.Errorf("Invalid type '%s' - must be one of 'post', 'page', or 'comment'", entryType)
+func entryTable(entryType string) (string, string, error) {
+	if err := validEntryType(entryType); err != nil {
+		return "", "", err
 	}
+	t := entryTables[entryType]
+	return t[0], t[1], nil
+}
 
-	var real_id string
-	if strings.Contains(id, "_") {
-		id_parts := strings.Split(id, "_")
-		real_id = id_parts[1]
-	} else {
-		real_id = id
+func (db *DB) UpdateLastCheck(entryType string, id string, last_check time.Time) error {
+	table, column, err := entryTable(entryType)
+	if err != nil {
+		return err
 	}
 
-	query := fmt.Sprintf("UPDATE %ss SET last_check = to_timestamp(%d), scheduled = $2 WHERE %s_id = $1",
-		entryType,
-		last_check,
-		entryType,
-	)
+	real_id := realID(id)
+
+	query := fmt.Sprintf("UPDATE %s SET last_check = $3, scheduled = $2 WHERE %s = $1", table, column)
 
 	log.Debugf("Running update: %s", query)
 
 	var something int
-	err := db.Conn.QueryRow(query, real_id, false).Scan(&something)
+	err = db.queryRow(query, real_id, false, last_check).Scan(&something)
 
 	if err != nil && err != sql.ErrNoRows {
 		return fmt.Errorf("Cannot update database: %s", err)
@@ -120,83 +166,678 @@ func (db *DB) UpdateLastCheck(entryType string, id string, last_check int64) err
 	return nil
 }
 
-func (db *DB) SetScheduled(entryType string, id string) error {
-	if entryType != "post" && entryType != "page" && entryType != "comment" {
-		return fmt.Errorf("Invalid type '%s' - must be one of 'post', 'page', or 'comment'", entryType)
+// Record a full re-fetch of an object's comments
+func (db *DB) UpdateLastFullCheck(entryType string, id string, last_check time.Time) error {
+	if entryType != "post" && entryType != "comment" {
+		return fmt.Errorf("Invalid type '%s' - must be one of 'post' or 'comment'", entryType)
 	}
-
-	var real_id string
-	if strings.Contains(id, "_") {
-		id_parts := strings.Split(id, "_")
-		real_id = id_parts[1]
-	} else {
-		real_id = id
+	table, column, err := entryTable(entryType)
+	if err != nil {
+		return err
 	}
 
-	query := fmt.Sprintf("UPDATE %ss SET scheduled = $1 WHERE %s_id = '%s'",
-		entryType,
-		entryType,
-		real_id,
-	)
+	query := fmt.Sprintf("UPDATE %s SET last_full_check = $2 WHERE %s = $1", table, column)
 
 	log.Debugf("Running update: %s", query)
 
-	var something int
-	err := db.Conn.QueryRow(query, true).Scan(&something)
+	if _, err := db.exec(query, realID(id), last_check); err != nil {
+		return fmt.Errorf("Cannot update database: %s", err)
+	}
 
-	if err != nil && err != sql.ErrNoRows {
+	return nil
+}
+
+func (db *DB) SetScheduled(entryType string, id string) error {
+	table, column, err := entryTable(entryType)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET scheduled = $1 WHERE %s = $2", table, column)
+
+	log.Debugf("Running update: %s", query)
+
+	if _, err := db.exec(query, true, realID(id)); err != nil {
 		return fmt.Errorf("Cannot update database: %s", err)
 	}
 
 	return nil
 }
 
-func (db *DB) InsertComment(comment_id string, post_id, parent_id string, user_id string) error {
-	log.Debugf("Storing new comment: %s / %s / %s / %s", comment_id, post_id, parent_id, user_id)
+// Store comment metadata, refreshing the Graph API counts
+//  if the comment is already known. Message changes are recorded
+//  as a new revision and comment.Revision is set accordingly.
+//  Until SetCommentPublished confirms a revision was published, it
+//  is reported as new or edited again.
+func (db *DB) UpsertComment(comment *fbbot.FBComment, post_id string) (CommentChange, error) {
+	comment_id := realID(comment.ID)
+	log.Debugf("Storing comment: %s / %s / %s / %s", comment_id, post_id, comment.Parent.ID, comment.From.ID)
 
 	var real_parent_id sql.NullString
-	if parent_id != "" {
-		parent_id_parts := strings.Split(parent_id, "_")
-		real_parent_id.String = parent_id_parts[1]
+	if comment.Parent.ID != "" {
+		real_parent_id.String = realID(comment.Parent.ID)
 		real_parent_id.Valid = true
 	}
 
-	query := `INSERT INTO comments (comment_id, post_id, parent_id, user_id)
+	hash := messageHash(comment.Message)
+
+	tx, err := db.begin()
+	if err != nil {
+		return CommentUnchanged, fmt.Errorf("Cannot start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		old_hash  sql.NullString
+		revision  int
+		published int
+		deleted   bool
+	)
+	err = tx.QueryRow(`SELECT message_hash, revision, published_revision, deleted FROM comments WHERE comment_id = $1 FOR UPDATE`, comment_id).Scan(&old_hash, &revision, &published, &deleted)
+
+	change := CommentUnchanged
+	switch {
+	case err == sql.ErrNoRows:
+		query := `INSERT INTO comments (comment_id, post_id, parent_id, user_id, created_time, message_length, like_count, comment_count, message_hash, revision)
+				VALUES
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, 1)
+				ON CONFLICT (comment_id) DO NOTHING`
+
+		res, err := tx.Exec(query,
+			comment_id,
+			post_id,
+			real_parent_id,
+			comment.From.ID,
+			comment.CreatedTime,
+			utf8.RuneCountInString(comment.Message),
+			comment.LikeCount,
+			comment.CommentCount,
+			hash,
+		)
+		if err != nil {
+			return change, fmt.Errorf("Cannot insert comment (%s, %s, %s, %s: %s", comment_id, post_id, real_parent_id.String, comment.From.ID, err)
+		}
+		if inserted, _ := res.RowsAffected(); inserted == 0 {
+			// Somebody else stored it in the meantime
+			log.Debugf("Comment %s was inserted concurrently", comment_id)
+			return change, nil
+		}
+		change = CommentNew
+		revision = 1
+	case err != nil:
+		return change, fmt.Errorf("Cannot load comment %s: %s", comment_id, err)
+	case !old_hash.Valid || old_hash.String != hash:
+		// Comments stored before revisions were tracked have no hash,
+		//  their first revision is recorded without calling it an edit
+		if old_hash.Valid {
+			change = CommentEdited
+		} else {
+			published = revision + 1
+		}
+		revision++
+		fallthrough
+	default:
+		// A change whose publishing failed
+		if change == CommentUnchanged && published < revision {
+			change = CommentEdited
+			if revision == 1 {
+				change = CommentNew
+			}
+		}
+		if deleted {
+			log.Warnf("Comment %s was marked deleted but is back", comment_id)
+		}
+
+		query := `UPDATE comments SET
+				created_time = $2,
+				message_length = $3,
+				like_count = $4,
+				comment_count = $5,
+				message_hash = $6,
+				revision = $7,
+				published_revision = $8,
+				deleted = 'f',
+				deleted_time = NULL,
+				updated = NOW()
+			WHERE comment_id = $1`
+
+		_, err := tx.Exec(query,
+			comment_id,
+			comment.CreatedTime,
+			utf8.RuneCountInString(comment.Message),
+			comment.LikeCount,
+			comment.CommentCount,
+			hash,
+			revision,
+			published,
+		)
+		if err != nil {
+			return change, fmt.Errorf("Cannot update comment %s: %s", comment_id, err)
+		}
+	}
+
+	if change != CommentUnchanged || !old_hash.Valid {
+		query := `INSERT INTO comment_revisions (comment_id, revision, message)
+				VALUES
+				($1, $2, $3)`
+
+		if _, err := tx.Exec(query, comment_id, revision, comment.Message); err != nil {
+			return change, fmt.Errorf("Cannot store revision %d of comment %s: %s", revision, comment_id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return change, fmt.Errorf("Cannot commit comment %s: %s", comment_id, err)
+	}
+
+	comment.Revision = revision
+	return change, nil
+}
+
+// Record that a revision of a comment was published to the storer
+//  and classifier
+func (db *DB) SetCommentPublished(comment_id string, revision int) error {
+	query := `UPDATE comments SET published_revision = $2
+			WHERE comment_id = $1
+			AND published_revision < $2`
+
+	if _, err := db.exec(query, realID(comment_id), revision); err != nil {
+		return fmt.Errorf("Cannot mark comment %s published: %s", comment_id, err)
+	}
+	return nil
+}
+
+// Mark the direct child comments of a post or comment as deleted
+//  unless they are in seen (the Graph API IDs returned by a full
+//  re-fetch). Returns the IDs of the newly deleted comments.
+func (db *DB) MarkDeletedComments(entryType string, id string, seen []string) ([]string, error) {
+	var parent_clause string
+	switch entryType {
+	case "post":
+		parent_clause = "post_id = $1 AND (parent_id IS NULL OR parent_id = '')"
+	case "comment":
+		parent_clause = "parent_id = $1"
+	default:
+		return nil, fmt.Errorf("Invalid type '%s' - must be one of 'post' or 'comment'", entryType)
+	}
+
+	seen_ids := make([]string, 0, len(seen))
+	for _, seen_id := range seen {
+		seen_ids = append(seen_ids, realID(seen_id))
+	}
+
+	query := fmt.Sprintf(`UPDATE comments SET deleted = 't', deleted_time = NOW()
+			WHERE %s
+			AND deleted = 'f'
+			AND NOT (comment_id = ANY($2))
+			RETURNING comment_id`, parent_clause)
+
+	rows, err := db.query(query, realID(id), pq.Array(seen_ids))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot mark deleted comments of %s: %s", id, err)
+	}
+	defer rows.Close()
+
+	deleted := make([]string, 0)
+	for rows.Next() {
+		var comment_id string
+		if err := rows.Scan(&comment_id); err != nil {
+			return deleted, fmt.Errorf("Scan error: %s", err)
+		}
+		deleted = append(deleted, comment_id)
+	}
+
+	return deleted, rows.Err()
+}
+
+func (db *DB) GetCommentRevisions(comment_id string) ([]CommentRevision, error) {
+	query := `SELECT comment_id, revision, message, created FROM comment_revisions
+			WHERE comment_id = $1
+			ORDER BY revision`
+
+	rows, err := db.query(query, realID(comment_id))
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	revisions := make([]CommentRevision, 0)
+	for rows.Next() {
+		revision := CommentRevision{}
+		if err := rows.Scan(&revision.CommentID, &revision.Revision, &revision.Message, &revision.Created); err != nil {
+			return revisions, fmt.Errorf("Scan error: %s", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Comments of an author created since the given time, oldest first
+func (db *DB) GetUserComments(userID string, since time.Time) ([]UserComment, error) {
+	query := `SELECT comment_id, user_id, post_id, created_time FROM comments
+			WHERE user_id = $1 AND created_time >= $2
+			ORDER BY created_time`
+
+	return db.queryUserComments(query, userID, since)
+}
+
+// Comments of all authors created since the given time, oldest first
+func (db *DB) GetCommentsSince(since time.Time) ([]UserComment, error) {
+	query := `SELECT comment_id, user_id, post_id, created_time FROM comments
+			WHERE created_time >= $1
+			ORDER BY created_time`
+
+	return db.queryUserComments(query, since)
+}
+
+// Scan (comment_id, user_id, post_id, created_time) rows
+func (db *DB) queryUserComments(query string, args ...interface{}) ([]UserComment, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	comments := make([]UserComment, 0)
+	for rows.Next() {
+		comment := UserComment{}
+		if err := rows.Scan(&comment.CommentID, &comment.UserID, &comment.PostID, &comment.CreatedTime); err != nil {
+			return comments, fmt.Errorf("Scan error: %s", err)
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// Replies written by an author since the given time, oldest first
+func (db *DB) GetUserReplies(userID string, since time.Time) ([]Reply, error) {
+	query := `SELECT c.comment_id, c.user_id, c.parent_id, p.user_id, c.created_time FROM comments c
+			JOIN comments p ON p.comment_id = c.parent_id
+			WHERE c.user_id = $1 AND c.created_time >= $2
+			ORDER BY c.created_time`
+
+	return db.queryReplies(query, userID, since)
+}
+
+// Replies to a comment, oldest first. Replies without a created_time
+//  are left out.
+func (db *DB) GetCommentReplies(comment_id string) ([]Reply, error) {
+	query := `SELECT c.comment_id, c.user_id, c.parent_id, p.user_id, c.created_time FROM comments c
+			JOIN comments p ON p.comment_id = c.parent_id
+			WHERE c.parent_id = $1 AND c.created_time IS NOT NULL
+			ORDER BY c.created_time`
+
+	return db.queryReplies(query, realID(comment_id))
+}
+
+// Scan (comment_id, user_id, parent_id, parent user_id, created_time) rows
+func (db *DB) queryReplies(query string, args ...interface{}) ([]Reply, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	replies := make([]Reply, 0)
+	for rows.Next() {
+		reply := Reply{}
+		if err := rows.Scan(&reply.CommentID, &reply.UserID, &reply.ParentID, &reply.TargetUserID, &reply.CreatedTime); err != nil {
+			return replies, fmt.Errorf("Scan error: %s", err)
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, rows.Err()
+}
+
+func (db *DB) InsertPage(id string, name string, link string) error {
+	log.Debugf("Storing new page: %s / %s / %s", id, name, link)
+
+	query := `INSERT INTO pages (page_id, name, link)
 			VALUES
-			($1, $2, $3, $4)`
+			($1, $2, $3)`
 
 	var ignore int
-	err := db.Conn.QueryRow(query, comment_id, post_id, real_parent_id, user_id).Scan(&ignore)
+	err := db.queryRow(query, id, name, link).Scan(&ignore)
 
 	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("Cannot insert comment (%s, %s, %s, %s: %s", comment_id, post_id, real_parent_id.String, user_id, err)
+		return fmt.Errorf("Cannot insert page %s: %s", id, err)
 	}
 
 	return nil
 }
 
-func (db *DB) InsertPost(id string) error {
-	log.Debugf("Storing new post: %s", id)
-	id_parts := strings.Split(id, "_")
+// Store post metadata, refreshing it if the post is already known
+func (db *DB) InsertPost(post *fbbot.FBPost) error {
+	log.Debugf("Storing post: %s", post.ID)
+	id_parts := strings.Split(post.ID, "_")
 
-	query := `INSERT INTO posts (post_id, page_id)
+	query := `INSERT INTO posts (post_id, page_id, created_time, message_length)
 			VALUES
-			($1, $2)`
+			($1, $2, $3, $4)
+			ON CONFLICT (post_id) DO UPDATE SET
+				created_time = EXCLUDED.created_time,
+				message_length = EXCLUDED.message_length,
+				updated = NOW()`
 
 	var ignore int
-	err := db.Conn.QueryRow(query, id_parts[1], id_parts[0]).Scan(&ignore)
+	err := db.queryRow(query,
+		id_parts[1],
+		id_parts[0],
+		post.CreatedTime,
+		utf8.RuneCountInString(post.Message),
+	).Scan(&ignore)
 
-	switch {
-	case err == sql.ErrNoRows:
-		return nil
-	case err == nil:
-		return nil
-	case strings.Contains(err.Error(), "duplicate key"):
-		log.Warnf("Warning - post %s already in database", id)
-		return nil
-	case err != nil:
+	if err != nil && err != sql.ErrNoRows {
 		return fmt.Errorf("Cannot insert post: %s", err)
 	}
 
 	return nil
 }
+
+// Look up the page a post belongs to
+func (db *DB) GetPostPage(post_id string) (string, error) {
+	var page_id string
+	err := db.queryRow(`SELECT page_id FROM posts WHERE post_id = $1`, realID(post_id)).Scan(&page_id)
+	if err != nil {
+		return "", fmt.Errorf("Cannot load post %s: %s", post_id, err)
+	}
+
+	return page_id, nil
+}
+
+func (db *DB) InsertVerdict(verdict *fbbot.Verdict) error {
+	log.Debugf("Storing %s verdict for comment %s: %s (%3.5f)", verdict.Method, verdict.CommentID, verdict.Band, verdict.Score)
+
+	query := `INSERT INTO verdicts (comment_id, user_id, matched_id, score, band, method)
+			VALUES
+			($1, $2, $3, $4, $5, $6)`
+
+	var ignore int
+	err := db.queryRow(query, realID(verdict.CommentID), verdict.UserID, realID(verdict.MatchedID), verdict.Score, verdict.Band, verdict.Method).Scan(&ignore)
+
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("Cannot insert verdict for %s: %s", verdict.CommentID, err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetUserVerdicts(userID string) ([]fbbot.Verdict, error) {
+	query := `SELECT comment_id, user_id, matched_id, score, band, method, created FROM verdicts
+			WHERE user_id = $1
+			ORDER BY created`
+
+	rows, err := db.query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	verdicts := make([]fbbot.Verdict, 0)
+	for rows.Next() {
+		verdict := fbbot.Verdict{}
+		if err := rows.Scan(&verdict.CommentID, &verdict.UserID, &verdict.MatchedID, &verdict.Score, &verdict.Band, &verdict.Method, &verdict.Created); err != nil {
+			return verdicts, fmt.Errorf("Scan error: %s", err)
+		}
+		verdicts = append(verdicts, verdict)
+	}
+
+	return verdicts, rows.Err()
+}
+
+// Put a comment and the comment it was found to duplicate into the
+//  same campaign. Starts a new campaign if neither is in one yet and
+//  merges campaigns if both are. Returns the updated campaign.
+func (db *DB) AddToCampaign(comment_id string, matched_id string, message string, similarity float64) (*fbbot.Campaign, error) {
+	comment_id = realID(comment_id)
+	matched_id = realID(matched_id)
+
+	tx, err := db.begin()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	// Serializes classifiers adding either comment before reading
+	//  their campaigns. Locked in ID order so (A, X) and (B, A) at the
+	//  same time cannot deadlock.
+	ids := []string{comment_id, matched_id}
+	sort.Strings(ids)
+	for _, id := range ids {
+		var ignore int
+		err := tx.QueryRow(`SELECT 1 FROM comments WHERE comment_id = $1 FOR UPDATE`, id).Scan(&ignore)
+		if err == sql.ErrNoRows && id == comment_id {
+			// Not stored by the fetcher, only the matched comment is added
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load comment %s: %s", id, err)
+		}
+	}
+
+	comment_campaign, err := txCampaignOf(tx, comment_id)
+	if err != nil {
+		return nil, err
+	}
+	matched_campaign, err := txCampaignOf(tx, matched_id)
+	if err != nil {
+		return nil, err
+	}
+
+	var campaign_id int64
+	switch {
+	case comment_campaign == 0 && matched_campaign == 0:
+		err := tx.QueryRow(`INSERT INTO campaigns (message) VALUES ($1) RETURNING campaign_id`, message).Scan(&campaign_id)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot create campaign: %s", err)
+		}
+		log.Infof("Started campaign %d with comments %s and %s", campaign_id, matched_id, comment_id)
+	case comment_campaign == 0:
+		campaign_id = matched_campaign
+	case matched_campaign == 0 || matched_campaign == comment_campaign:
+		campaign_id = comment_campaign
+	default:
+		// Keep the older campaign
+		campaign_id = matched_campaign
+		merged := comment_campaign
+		if merged < campaign_id {
+			campaign_id, merged = merged, campaign_id
+		}
+		if _, err := tx.Exec(`UPDATE campaign_comments SET campaign_id = $1 WHERE campaign_id = $2`, campaign_id, merged); err != nil {
+			return nil, fmt.Errorf("Cannot merge campaign %d into %d: %s", merged, campaign_id, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM campaigns WHERE campaign_id = $1`, merged); err != nil {
+			return nil, fmt.Errorf("Cannot delete merged campaign %d: %s", merged, err)
+		}
+		log.Infof("Merged campaign %d into %d", merged, campaign_id)
+	}
+
+	// The matched comment is the reference, similarity 1
+	query := `INSERT INTO campaign_comments (campaign_id, comment_id, user_id, page_id, created_time, similarity)
+			SELECT $1, c.comment_id, c.user_id, p.page_id, c.created_time, $3
+			FROM comments c JOIN posts p ON p.post_id = c.post_id
+			WHERE c.comment_id = $2
+			ON CONFLICT (comment_id) DO NOTHING`
+	if _, err := tx.Exec(query, campaign_id, matched_id, 1.0); err != nil {
+		return nil, fmt.Errorf("Cannot add comment %s to campaign %d: %s", matched_id, campaign_id, err)
+	}
+	if _, err := tx.Exec(query, campaign_id, comment_id, similarity); err != nil {
+		return nil, fmt.Errorf("Cannot add comment %s to campaign %d: %s", comment_id, campaign_id, err)
+	}
+
+	campaign := &fbbot.Campaign{}
+	err = tx.QueryRow(`UPDATE campaigns SET
+				comment_count = s.comment_count,
+				user_count = s.user_count,
+				page_count = s.page_count,
+				first_seen = s.first_seen,
+				last_seen = s.last_seen,
+				updated = NOW()
+			FROM (SELECT count(*) AS comment_count,
+					count(DISTINCT user_id) AS user_count,
+					count(DISTINCT page_id) AS page_count,
+					min(created_time) AS first_seen,
+					max(created_time) AS last_seen
+				FROM campaign_comments WHERE campaign_id = $1) s
+			WHERE campaign_id = $1
+			RETURNING campaign_id, message, comment_count, user_count, page_count, first_seen, last_seen, created`, campaign_id).Scan(
+		&campaign.ID,
+		&campaign.Message,
+		&campaign.Comments,
+		&campaign.Authors,
+		&campaign.Pages,
+		&campaign.FirstSeen,
+		&campaign.LastSeen,
+		&campaign.Created,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot update campaign %d: %s", campaign_id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Cannot commit campaign %d: %s", campaign_id, err)
+	}
+
+	return campaign, nil
+}
+
+// Campaign a comment belongs to, 0 if none
+func txCampaignOf(tx *tracedTx, comment_id string) (int64, error) {
+	var campaign_id int64
+	err := tx.QueryRow(`SELECT campaign_id FROM campaign_comments WHERE comment_id = $1`, comment_id).Scan(&campaign_id)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("Cannot load campaign of comment %s: %s", comment_id, err)
+	}
+	return campaign_id, nil
+}
+
+// Campaigns active since the given time with at least minAuthors
+//  distinct authors, biggest first
+func (db *DB) GetCampaigns(since time.Time, minAuthors int) ([]fbbot.Campaign, error) {
+	query := `SELECT campaign_id, message, comment_count, user_count, page_count, first_seen, last_seen, created FROM campaigns
+			WHERE last_seen >= $1 AND user_count >= $2
+			ORDER BY user_count DESC, comment_count DESC`
+
+	rows, err := db.query(query, since, minAuthors)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	campaigns := make([]fbbot.Campaign, 0)
+	for rows.Next() {
+		campaign := fbbot.Campaign{}
+		err := rows.Scan(
+			&campaign.ID,
+			&campaign.Message,
+			&campaign.Comments,
+			&campaign.Authors,
+			&campaign.Pages,
+			&campaign.FirstSeen,
+			&campaign.LastSeen,
+			&campaign.Created,
+		)
+		if err != nil {
+			return campaigns, fmt.Errorf("Scan error: %s", err)
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, rows.Err()
+}
+
+func (db *DB) GetCampaignMembers(campaign_id int64) ([]fbbot.CampaignMember, error) {
+	query := `SELECT campaign_id, comment_id, user_id, page_id, created_time, similarity FROM campaign_comments
+			WHERE campaign_id = $1
+			ORDER BY created_time`
+
+	rows, err := db.query(query, campaign_id)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	members := make([]fbbot.CampaignMember, 0)
+	for rows.Next() {
+		member := fbbot.CampaignMember{}
+		if err := rows.Scan(&member.CampaignID, &member.CommentID, &member.UserID, &member.PageID, &member.CreatedTime, &member.Similarity); err != nil {
+			return members, fmt.Errorf("Scan error: %s", err)
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// Replace all stored networks with the result of a new analysis
+func (db *DB) ReplaceNetworks(networks []fbbot.Network) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("Cannot start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM networks`); err != nil {
+		return fmt.Errorf("Cannot delete networks: %s", err)
+	}
+
+	for _, network := range networks {
+		var network_id int64
+		err := tx.QueryRow(`INSERT INTO networks (size, density, weight) VALUES ($1, $2, $3) RETURNING network_id`,
+			len(network.Members),
+			network.Density,
+			network.Weight,
+		).Scan(&network_id)
+		if err != nil {
+			return fmt.Errorf("Cannot insert network: %s", err)
+		}
+
+		for _, user_id := range network.Members {
+			if _, err := tx.Exec(`INSERT INTO network_members (network_id, user_id) VALUES ($1, $2)`, network_id, user_id); err != nil {
+				return fmt.Errorf("Cannot add %s to network %d: %s", user_id, network_id, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Cannot commit networks: %s", err)
+	}
+
+	return nil
+}
+
+// The network an author belongs to, nil if none
+func (db *DB) GetUserNetwork(userID string) (*fbbot.Network, error) {
+	network := &fbbot.Network{}
+	query := `SELECT n.network_id, n.density, n.weight, n.created FROM networks n
+			JOIN network_members m ON m.network_id = n.network_id
+			WHERE m.user_id = $1`
+
+	err := db.queryRow(query, userID).Scan(&network.ID, &network.Density, &network.Weight, &network.Created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load network of %s: %s", userID, err)
+	}
+
+	rows, err := db.query(`SELECT user_id FROM network_members WHERE network_id = $1 ORDER BY user_id`, network.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Database query failed: %s", err)
+	}
+	defer rows.Close()
+
+	network.Members = make([]string, 0)
+	for rows.Next() {
+		var user_id string
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, fmt.Errorf("Scan error: %s", err)
+		}
+		network.Members = append(network.Members, user_id)
+	}
+
+	return network, rows.Err()
+}
@@ -0,0 +1,675 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// Memory is a Store which keeps everything in process memory.
+//  Nothing survives a restart - use it for tests and for running
+//  the whole pipeline inside one process.
+type Memory struct {
+	Config *config.DBConfig
+
+	mu        sync.Mutex
+	pages     map[string]*memObject
+	posts     map[string]*memObject
+	comments  map[string]*memObject
+	revisions map[string][]CommentRevision
+	verdicts  []fbbot.Verdict
+
+	campaigns    map[int64]*fbbot.Campaign
+	members      map[string]fbbot.CampaignMember // by comment ID
+	lastCampaign int64
+
+	networks    map[string]*fbbot.Network // by user ID
+	lastNetwork int64
+}
+
+type memObject struct {
+	id            string
+	parentID      string // page ID for posts, post ID for comments
+	replyTo       string // parent comment ID for sub-comments
+	userID        string
+	createdTime   time.Time
+	messageLength int
+	likeCount     int32
+	commentCount  int32
+	messageHash   string
+	revision      int
+	published     int
+	deleted       bool
+	deletedTime   time.Time
+	lastCheck     time.Time
+	lastFullCheck time.Time
+	scheduled     bool
+}
+
+func NewMemory(cfg *config.DBConfig) *Memory {
+	return &Memory{
+		Config:    cfg,
+		pages:     make(map[string]*memObject),
+		posts:     make(map[string]*memObject),
+		comments:  make(map[string]*memObject),
+		revisions: make(map[string][]CommentRevision),
+		verdicts:  make([]fbbot.Verdict, 0),
+		campaigns: make(map[int64]*fbbot.Campaign),
+		members:   make(map[string]fbbot.CampaignMember),
+		networks:  make(map[string]*fbbot.Network),
+	}
+}
+
+// Connect seeds the configured pages, there is nothing to connect to
+func (m *Memory) Connect() error {
+	log.Info("Using in-memory database")
+	for _, page := range m.Config.Pages {
+		if err := m.InsertPage(page, page, fmt.Sprintf("https://www.facebook.com/%s/", page)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The in-memory database is always there
+func (m *Memory) Ping() error {
+	return nil
+}
+
+func (m *Memory) WithContext(ctx context.Context) Store {
+	return m
+}
+
+func (m *Memory) InsertPage(id string, name string, link string) error {
+	log.Debugf("Storing new page: %s / %s / %s", id, name, link)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pages[id]; ok {
+		return fmt.Errorf("Cannot insert page %s: already exists", id)
+	}
+	m.pages[id] = &memObject{id: id}
+
+	return nil
+}
+
+func (m *Memory) InsertPost(post *fbbot.FBPost) error {
+	log.Debugf("Storing post: %s", post.ID)
+	id_parts := strings.Split(post.ID, "_")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.posts[id_parts[1]]
+	if !ok {
+		if _, ok := m.pages[id_parts[0]]; !ok {
+			return fmt.Errorf("Cannot insert post: unknown page %s", id_parts[0])
+		}
+		obj = &memObject{id: id_parts[1], parentID: id_parts[0]}
+		m.posts[id_parts[1]] = obj
+	}
+	obj.createdTime = post.CreatedTime.Time
+	obj.messageLength = utf8.RuneCountInString(post.Message)
+
+	return nil
+}
+
+func (m *Memory) GetPostPage(post_id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[realID(post_id)]
+	if !ok {
+		return "", fmt.Errorf("Cannot load post %s: not found", post_id)
+	}
+
+	return post.parentID, nil
+}
+
+func (m *Memory) UpsertComment(comment *fbbot.FBComment, post_id string) (CommentChange, error) {
+	comment_id := realID(comment.ID)
+	log.Debugf("Storing comment: %s / %s / %s / %s", comment_id, post_id, comment.Parent.ID, comment.From.ID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := messageHash(comment.Message)
+	change := CommentUnchanged
+
+	obj, ok := m.comments[comment_id]
+	switch {
+	case !ok:
+		if _, ok := m.posts[post_id]; !ok {
+			return change, fmt.Errorf("Cannot insert comment (%s, %s, %s, %s): unknown post", comment_id, post_id, comment.Parent.ID, comment.From.ID)
+		}
+
+		var reply_to string
+		if comment.Parent.ID != "" {
+			reply_to = realID(comment.Parent.ID)
+		}
+		obj = &memObject{
+			id:       comment_id,
+			parentID: post_id,
+			replyTo:  reply_to,
+			userID:   comment.From.ID,
+		}
+		m.comments[comment_id] = obj
+		change = CommentNew
+	case obj.messageHash != hash:
+		change = CommentEdited
+	}
+
+	if obj.deleted {
+		log.Warnf("Comment %s was marked deleted but is back", comment_id)
+	}
+
+	if change != CommentUnchanged {
+		obj.revision++
+		m.revisions[comment_id] = append(m.revisions[comment_id], CommentRevision{
+			CommentID: comment_id,
+			Revision:  obj.revision,
+			Message:   comment.Message,
+			Created:   time.Now(),
+		})
+	} else if obj.published < obj.revision {
+		// A change whose publishing failed
+		change = CommentEdited
+		if obj.revision == 1 {
+			change = CommentNew
+		}
+	}
+	obj.createdTime = comment.CreatedTime.Time
+	obj.messageLength = utf8.RuneCountInString(comment.Message)
+	obj.likeCount = comment.LikeCount
+	obj.commentCount = comment.CommentCount
+	obj.messageHash = hash
+	obj.deleted = false
+	obj.deletedTime = time.Time{}
+
+	comment.Revision = obj.revision
+	return change, nil
+}
+
+func (m *Memory) SetCommentPublished(comment_id string, revision int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.comments[realID(comment_id)]
+	if !ok {
+		return fmt.Errorf("Cannot mark comment %s published: unknown comment", comment_id)
+	}
+	if obj.published < revision {
+		obj.published = revision
+	}
+	return nil
+}
+
+func (m *Memory) MarkDeletedComments(entryType string, id string, seen []string) ([]string, error) {
+	if entryType != "post" && entryType != "comment" {
+		return nil, fmt.Errorf("Invalid type '%s' - must be one of 'post' or 'comment'", entryType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen_ids := make(map[string]bool)
+	for _, seen_id := range seen {
+		seen_ids[realID(seen_id)] = true
+	}
+
+	parent_id := realID(id)
+	deleted := make([]string, 0)
+	for _, comment_id := range sortedIDs(m.comments) {
+		comment := m.comments[comment_id]
+		if comment.deleted || seen_ids[comment_id] {
+			continue
+		}
+		if (entryType == "post" && comment.parentID == parent_id && comment.replyTo == "") ||
+			(entryType == "comment" && comment.replyTo == parent_id) {
+			comment.deleted = true
+			comment.deletedTime = time.Now()
+			deleted = append(deleted, comment_id)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *Memory) GetCommentRevisions(comment_id string) ([]CommentRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revisions := make([]CommentRevision, 0)
+	return append(revisions, m.revisions[realID(comment_id)]...), nil
+}
+
+func (m *Memory) GetUserComments(userID string, since time.Time) ([]UserComment, error) {
+	return m.userComments(userID, since), nil
+}
+
+func (m *Memory) GetCommentsSince(since time.Time) ([]UserComment, error) {
+	return m.userComments("", since), nil
+}
+
+// Comments created since the given time, of one author unless
+//  userID is empty
+func (m *Memory) userComments(userID string, since time.Time) []UserComment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comments := make([]UserComment, 0)
+	for _, id := range sortedIDs(m.comments) {
+		comment := m.comments[id]
+		if (userID != "" && comment.userID != userID) || comment.createdTime.Before(since) {
+			continue
+		}
+		comments = append(comments, UserComment{
+			CommentID:   comment.id,
+			UserID:      comment.userID,
+			PostID:      comment.parentID,
+			CreatedTime: comment.createdTime,
+		})
+	}
+	sort.Sort(byUserCommentTime(comments))
+
+	return comments
+}
+
+func (m *Memory) GetUserReplies(userID string, since time.Time) ([]Reply, error) {
+	return m.replies(func(c *memObject) bool {
+		return c.userID == userID && !c.createdTime.Before(since)
+	}), nil
+}
+
+func (m *Memory) GetCommentReplies(comment_id string) ([]Reply, error) {
+	parent_id := realID(comment_id)
+	return m.replies(func(c *memObject) bool {
+		return c.replyTo == parent_id && !c.createdTime.IsZero()
+	}), nil
+}
+
+// Replies matching a filter, oldest first
+func (m *Memory) replies(match func(c *memObject) bool) []Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replies := make([]Reply, 0)
+	for _, id := range sortedIDs(m.comments) {
+		comment := m.comments[id]
+		if comment.replyTo == "" || !match(comment) {
+			continue
+		}
+		parent, ok := m.comments[comment.replyTo]
+		if !ok {
+			continue
+		}
+		replies = append(replies, Reply{
+			CommentID:    comment.id,
+			UserID:       comment.userID,
+			ParentID:     comment.replyTo,
+			TargetUserID: parent.userID,
+			CreatedTime:  comment.createdTime,
+		})
+	}
+	sort.Sort(byReplyTime(replies))
+
+	return replies
+}
+
+func (m *Memory) GetSchedulerPosts(delaySecs int) ([]fbbot.QueueEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(delaySecs) * time.Second)
+	entries := make([]fbbot.QueueEntry, 0)
+	for _, id := range sortedIDs(m.pages) {
+		page := m.pages[id]
+		if page.due(cutoff) {
+			entries = append(entries, page.queueEntry(id, "page"))
+		}
+	}
+
+	return entries, nil
+}
+
+func (m *Memory) GetSchedulerComments(delaySecs int, fullDelaySecs int) ([]fbbot.QueueEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(delaySecs) * time.Second)
+	entries := make([]fbbot.QueueEntry, 0)
+	for _, id := range sortedIDs(m.posts) {
+		post := m.posts[id]
+		if post.due(cutoff) {
+			entry := post.queueEntry(fmt.Sprintf("%s_%s", post.parentID, id), "post")
+			entry.Full = post.fullDue(fullDelaySecs)
+			entries = append(entries, entry)
+		}
+	}
+	for _, id := range sortedIDs(m.comments) {
+		comment := m.comments[id]
+		// Sub-comments cannot have more comments
+		if comment.replyTo == "" && !comment.deleted && comment.due(cutoff) {
+			entry := comment.queueEntry(fmt.Sprintf("%s_%s", comment.parentID, id), "comment")
+			entry.Full = comment.fullDue(fullDelaySecs)
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (m *Memory) UpdateLastCheck(entryType string, id string, last_check time.Time) error {
+	if err := validEntryType(entryType); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if obj, ok := m.objects(entryType)[realID(id)]; ok {
+		obj.lastCheck = last_check
+		obj.scheduled = false
+	}
+
+	return nil
+}
+
+func (m *Memory) UpdateLastFullCheck(entryType string, id string, last_check time.Time) error {
+	if entryType != "post" && entryType != "comment" {
+		return fmt.Errorf("Invalid type '%s' - must be one of 'post' or 'comment'", entryType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if obj, ok := m.objects(entryType)[realID(id)]; ok {
+		obj.lastFullCheck = last_check
+	}
+
+	return nil
+}
+
+func (m *Memory) SetScheduled(entryType string, id string) error {
+	if err := validEntryType(entryType); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if obj, ok := m.objects(entryType)[realID(id)]; ok {
+		obj.scheduled = true
+	}
+
+	return nil
+}
+
+func (m *Memory) InsertVerdict(verdict *fbbot.Verdict) error {
+	log.Debugf("Storing %s verdict for comment %s: %s (%3.5f)", verdict.Method, verdict.CommentID, verdict.Band, verdict.Score)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := *verdict
+	v.CommentID = realID(v.CommentID)
+	v.MatchedID = realID(v.MatchedID)
+	v.Created = time.Now()
+	m.verdicts = append(m.verdicts, v)
+
+	return nil
+}
+
+func (m *Memory) GetUserVerdicts(userID string) ([]fbbot.Verdict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	verdicts := make([]fbbot.Verdict, 0)
+	for _, verdict := range m.verdicts {
+		if verdict.UserID == userID {
+			verdicts = append(verdicts, verdict)
+		}
+	}
+
+	return verdicts, nil
+}
+
+func (m *Memory) AddToCampaign(comment_id string, matched_id string, message string, similarity float64) (*fbbot.Campaign, error) {
+	comment_id = realID(comment_id)
+	matched_id = realID(matched_id)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range []string{comment_id, matched_id} {
+		if _, ok := m.comments[id]; !ok {
+			return nil, fmt.Errorf("Cannot load comment %s: not found", id)
+		}
+	}
+
+	comment_campaign := m.members[comment_id].CampaignID
+	matched_campaign := m.members[matched_id].CampaignID
+
+	var campaign_id int64
+	switch {
+	case comment_campaign == 0 && matched_campaign == 0:
+		m.lastCampaign++
+		campaign_id = m.lastCampaign
+		m.campaigns[campaign_id] = &fbbot.Campaign{
+			ID:      campaign_id,
+			Message: message,
+			Created: time.Now(),
+		}
+		log.Infof("Started campaign %d with comments %s and %s", campaign_id, matched_id, comment_id)
+	case comment_campaign == 0:
+		campaign_id = matched_campaign
+	case matched_campaign == 0 || matched_campaign == comment_campaign:
+		campaign_id = comment_campaign
+	default:
+		// Keep the older campaign
+		campaign_id = matched_campaign
+		merged := comment_campaign
+		if merged < campaign_id {
+			campaign_id, merged = merged, campaign_id
+		}
+		for id, member := range m.members {
+			if member.CampaignID == merged {
+				member.CampaignID = campaign_id
+				m.members[id] = member
+			}
+		}
+		delete(m.campaigns, merged)
+		log.Infof("Merged campaign %d into %d", merged, campaign_id)
+	}
+
+	// The matched comment is the reference, similarity 1
+	m.addCampaignMember(campaign_id, matched_id, 1)
+	m.addCampaignMember(campaign_id, comment_id, similarity)
+
+	campaign := m.campaigns[campaign_id]
+	users := make(map[string]bool)
+	pages := make(map[string]bool)
+	campaign.Comments = 0
+	campaign.FirstSeen = fbbot.GraphTime{}
+	campaign.LastSeen = fbbot.GraphTime{}
+	for _, member := range m.members {
+		if member.CampaignID != campaign_id {
+			continue
+		}
+		campaign.Comments++
+		users[member.UserID] = true
+		pages[member.PageID] = true
+		if t := member.CreatedTime; !t.IsZero() {
+			if campaign.FirstSeen.IsZero() || t.Before(campaign.FirstSeen.Time) {
+				campaign.FirstSeen = t
+			}
+			if t.After(campaign.LastSeen.Time) {
+				campaign.LastSeen = t
+			}
+		}
+	}
+	campaign.Authors = len(users)
+	campaign.Pages = len(pages)
+
+	c := *campaign
+	return &c, nil
+}
+
+// Caller must hold the lock
+func (m *Memory) addCampaignMember(campaign_id int64, comment_id string, similarity float64) {
+	if _, ok := m.members[comment_id]; ok {
+		return
+	}
+
+	comment := m.comments[comment_id]
+	var page_id string
+	if post, ok := m.posts[comment.parentID]; ok {
+		page_id = post.parentID
+	}
+
+	m.members[comment_id] = fbbot.CampaignMember{
+		CampaignID:  campaign_id,
+		CommentID:   comment_id,
+		UserID:      comment.userID,
+		PageID:      page_id,
+		CreatedTime: fbbot.NewGraphTime(comment.createdTime),
+		Similarity:  similarity,
+	}
+}
+
+func (m *Memory) GetCampaigns(since time.Time, minAuthors int) ([]fbbot.Campaign, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	campaigns := make([]fbbot.Campaign, 0)
+	for _, campaign := range m.campaigns {
+		if campaign.LastSeen.Before(since) || campaign.Authors < minAuthors {
+			continue
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+	sort.Sort(byCampaignSize(campaigns))
+
+	return campaigns, nil
+}
+
+func (m *Memory) GetCampaignMembers(campaign_id int64) ([]fbbot.CampaignMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]fbbot.CampaignMember, 0)
+	for _, member := range m.members {
+		if member.CampaignID == campaign_id {
+			members = append(members, member)
+		}
+	}
+	sort.Sort(byCreatedTime(members))
+
+	return members, nil
+}
+
+func (m *Memory) ReplaceNetworks(networks []fbbot.Network) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.networks = make(map[string]*fbbot.Network)
+	for _, network := range networks {
+		m.lastNetwork++
+		n := network
+		n.ID = m.lastNetwork
+		n.Created = time.Now()
+		for _, user_id := range n.Members {
+			m.networks[user_id] = &n
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) GetUserNetwork(userID string) (*fbbot.Network, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	network, ok := m.networks[userID]
+	if !ok {
+		return nil, nil
+	}
+	n := *network
+	return &n, nil
+}
+
+func (m *Memory) objects(entryType string) map[string]*memObject {
+	switch entryType {
+	case "page":
+		return m.pages
+	case "post":
+		return m.posts
+	}
+	return m.comments
+}
+
+// An object is due when it isn't scheduled already and was
+//  last checked before the cutoff (or never)
+func (o *memObject) due(cutoff time.Time) bool {
+	return !o.scheduled && (o.lastCheck.IsZero() || o.lastCheck.Before(cutoff))
+}
+
+// A full re-fetch is due if there never was one or the last
+//  one is older than fullDelaySecs (if enabled)
+func (o *memObject) fullDue(fullDelaySecs int) bool {
+	if o.lastFullCheck.IsZero() {
+		return true
+	}
+	return fullDelaySecs > 0 && o.lastFullCheck.Before(time.Now().Add(-time.Duration(fullDelaySecs)*time.Second))
+}
+
+func (o *memObject) queueEntry(id string, objType string) fbbot.QueueEntry {
+	return fbbot.QueueEntry{
+		ObjectID:    id,
+		ObjectType:  objType,
+		LastChecked: fbbot.NewGraphTime(o.lastCheck),
+	}
+}
+
+func sortedIDs(objects map[string]*memObject) []string {
+	ids := make([]string, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+type byCampaignSize []fbbot.Campaign
+
+func (c byCampaignSize) Len() int      { return len(c) }
+func (c byCampaignSize) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCampaignSize) Less(i, j int) bool {
+	if c[i].Authors != c[j].Authors {
+		return c[i].Authors > c[j].Authors
+	}
+	return c[i].Comments > c[j].Comments
+}
+
+type byCreatedTime []fbbot.CampaignMember
+
+func (c byCreatedTime) Len() int           { return len(c) }
+func (c byCreatedTime) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byCreatedTime) Less(i, j int) bool { return c[i].CreatedTime.Before(c[j].CreatedTime.Time) }
+
+type byUserCommentTime []UserComment
+
+func (c byUserCommentTime) Len() int           { return len(c) }
+func (c byUserCommentTime) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byUserCommentTime) Less(i, j int) bool { return c[i].CreatedTime.Before(c[j].CreatedTime) }
+
+type byReplyTime []Reply
+
+func (r byReplyTime) Len() int           { return len(r) }
+func (r byReplyTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byReplyTime) Less(i, j int) bool { return r[i].CreatedTime.Before(r[j].CreatedTime) }
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"strings"
+	"time"
+)
+
+// Store is the metadata storage used by the pipeline components.
+//  DB (postgres) is the main implementation, Memory is meant for
+//  tests and single-host setups.
+type Store interface {
+	Connect() error
+	Ping() error
+	// Same store, tracing its queries as part of ctx
+	WithContext(ctx context.Context) Store
+
+	// Pages
+	InsertPage(id string, name string, link string) error
+
+	// Posts and comments
+	InsertPost(post *fbbot.FBPost) error
+	GetPostPage(post_id string) (string, error)
+	UpsertComment(comment *fbbot.FBComment, post_id string) (CommentChange, error)
+	SetCommentPublished(comment_id string, revision int) error
+	MarkDeletedComments(entryType string, id string, seen []string) ([]string, error)
+	GetCommentRevisions(comment_id string) ([]CommentRevision, error)
+	GetUserComments(userID string, since time.Time) ([]UserComment, error)
+	GetCommentsSince(since time.Time) ([]UserComment, error)
+	GetUserReplies(userID string, since time.Time) ([]Reply, error)
+	GetCommentReplies(comment_id string) ([]Reply, error)
+
+	// Scheduling
+	GetSchedulerPosts(delaySecs int) ([]fbbot.QueueEntry, error)
+	GetSchedulerComments(delaySecs int, fullDelaySecs int) ([]fbbot.QueueEntry, error)
+	UpdateLastCheck(entryType string, id string, last_check time.Time) error
+	UpdateLastFullCheck(entryType string, id string, last_check time.Time) error
+	SetScheduled(entryType string, id string) error
+
+	// Verdicts
+	InsertVerdict(verdict *fbbot.Verdict) error
+	GetUserVerdicts(userID string) ([]fbbot.Verdict, error)
+
+	// Campaigns
+	AddToCampaign(comment_id string, matched_id string, message string, similarity float64) (*fbbot.Campaign, error)
+	GetCampaigns(since time.Time, minAuthors int) ([]fbbot.Campaign, error)
+	GetCampaignMembers(campaign_id int64) ([]fbbot.CampaignMember, error)
+
+	// Coordinated networks
+	ReplaceNetworks(networks []fbbot.Network) error
+	GetUserNetwork(userID string) (*fbbot.Network, error)
+}
+
+// What UpsertComment did with a comment
+type CommentChange int
+
+const (
+	CommentNew CommentChange = iota
+	CommentUnchanged
+	CommentEdited
+)
+
+func (c CommentChange) String() string {
+	switch c {
+	case CommentNew:
+		return "new"
+	case CommentEdited:
+		return "edited"
+	}
+	return "unchanged"
+}
+
+// One version of a comment's message
+type CommentRevision struct {
+	CommentID string
+	Revision  int
+	Message   string
+	Created   time.Time
+}
+
+// When an author commented where
+type UserComment struct {
+	CommentID   string
+	UserID      string
+	PostID      string
+	CreatedTime time.Time
+}
+
+// A comment replying to another one
+type Reply struct {
+	CommentID    string
+	UserID       string
+	ParentID     string
+	TargetUserID string // author of the parent comment
+	CreatedTime  time.Time
+}
+
+// Setup a Store based on the configured database type
+func NewStore(cfg *config.DBConfig) (Store, error) {
+	switch cfg.Type {
+	case "postgres":
+		return New(cfg), nil
+	case "memory":
+		return NewMemory(cfg), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported database type '%s' - must be one of 'postgres' or 'memory'", cfg.Type)
+}
+
+func validEntryType(entryType string) error {
+	if entryType != "post" && entryType != "page" && entryType != "comment" {
+		return fmt.Errorf("Invalid type '%s' - must be one of 'post', 'page', or 'comment'", entryType)
+	}
+	return nil
+}
+
+// Strip the "<parent>_" prefix off Graph API IDs
+func realID(id string) string {
+	if strings.Contains(id, "_") {
+		return strings.Split(id, "_")[1]
+	}
+	return id
+}
+
+// Hash used to detect edited comments
+func messageHash(message string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(message)))
+}
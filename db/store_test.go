@@ -0,0 +1,308 @@
+package db
+
+import (
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Stores the same tests run against: Memory, and DB if FBBOTSCAN_TEST_DB
+//  holds the connection string of a postgres database with the schema
+//  loaded. Tests only touch objects with IDs of their own, see ids.
+func stores(t *testing.T) map[string]Store {
+	memory := NewMemory(&config.DBConfig{Type: "memory"})
+	if err := memory.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{"memory": memory}
+
+	if connstr := os.Getenv("FBBOTSCAN_TEST_DB"); connstr != "" {
+		db := New(&config.DBConfig{Type: "postgres", Connstr: connstr})
+		if err := db.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		stores["postgres"] = db
+	}
+
+	return stores
+}
+
+// Makes IDs unique to a test run, so runs against the same database
+//  don't see each other's objects
+func ids() func(name string) string {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return func(name string) string {
+		return name + run
+	}
+}
+
+var created = time.Date(2018, 3, 4, 12, 0, 0, 0, time.UTC)
+
+type fixture struct {
+	t     *testing.T
+	store Store
+	id    func(name string) string
+}
+
+// Page and post for the comments of a test, returns the post ID
+func (f *fixture) post() string {
+	page, post := f.id("page"), f.id("post")
+	if err := f.store.InsertPage(page, "Page", "https://www.facebook.com/"+page+"/"); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.store.InsertPost(&fbbot.FBPost{ID: page + "_" + post, CreatedTime: fbbot.NewGraphTime(created)}); err != nil {
+		f.t.Fatal(err)
+	}
+	return post
+}
+
+// Store a comment by user, minutes after created, replying to parent
+//  unless it is empty
+func (f *fixture) comment(post string, name string, user string, parent string, minutes int, message string) (*fbbot.FBComment, CommentChange) {
+	c := &fbbot.FBComment{
+		ID:          post + "_" + f.id(name),
+		Message:     message,
+		CreatedTime: fbbot.NewGraphTime(created.Add(time.Duration(minutes) * time.Minute)),
+	}
+	c.From.ID = f.id(user)
+	if parent != "" {
+		c.Parent.ID = post + "_" + f.id(parent)
+	}
+	change, err := f.store.UpsertComment(c, post)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return c, change
+}
+
+func (f *fixture) names(ids []string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, id[:len(id)-len(f.id(""))])
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fixture) check(what string, got interface{}, want interface{}) {
+	if !reflect.DeepEqual(got, want) {
+		f.t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestStores(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(f *fixture)
+	}{
+		{"comment revisions", testRevisions},
+		{"comments and replies", testReplies},
+		{"deleted comments", testDeleted},
+		{"scheduling", testScheduling},
+		{"campaigns", testCampaigns},
+	}
+
+	for name, store := range stores(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				tt.run(&fixture{t: t, store: store, id: ids()})
+			})
+		}
+	}
+}
+
+func testRevisions(f *fixture) {
+	post := f.post()
+
+	c, change := f.comment(post, "c", "u", "", 0, "first")
+	f.check("new comment", change, CommentNew)
+	f.check("revision", c.Revision, 1)
+
+	_, change = f.comment(post, "c", "u", "", 0, "first")
+	f.check("unpublished comment", change, CommentNew)
+
+	if err := f.store.SetCommentPublished(c.ID, 1); err != nil {
+		f.t.Fatal(err)
+	}
+	_, change = f.comment(post, "c", "u", "", 0, "first")
+	f.check("published comment", change, CommentUnchanged)
+
+	c, change = f.comment(post, "c", "u", "", 0, "second")
+	f.check("edited comment", change, CommentEdited)
+	f.check("revision", c.Revision, 2)
+
+	_, change = f.comment(post, "c", "u", "", 0, "second")
+	f.check("unpublished edit", change, CommentEdited)
+
+	revisions, err := f.store.GetCommentRevisions(c.ID)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	messages := make([]string, 0)
+	for _, r := range revisions {
+		messages = append(messages, fmt.Sprintf("%d:%s", r.Revision, r.Message))
+	}
+	f.check("revisions", messages, []string{"1:first", "2:second"})
+}
+
+func testReplies(f *fixture) {
+	post := f.post()
+	f.comment(post, "c1", "u1", "", 0, "top")
+	f.comment(post, "c2", "u2", "c1", 1, "reply")
+	f.comment(post, "c3", "u2", "c1", 2, "another reply")
+	f.comment(post, "c4", "u2", "", 3, "other top")
+
+	comments, err := f.store.GetUserComments(f.id("u2"), created.Add(90*time.Second))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, c := range comments {
+		got = append(got, c.CommentID)
+		f.check("post of "+c.CommentID, c.PostID, post)
+	}
+	f.check("user comments", f.names(got), []string{"c3", "c4"})
+
+	replies, err := f.store.GetUserReplies(f.id("u2"), created)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	got = make([]string, 0)
+	for _, r := range replies {
+		got = append(got, r.CommentID)
+		f.check("target of "+r.CommentID, r.TargetUserID, f.id("u1"))
+	}
+	f.check("user replies", f.names(got), []string{"c2", "c3"})
+
+	replies, err = f.store.GetCommentReplies(post + "_" + f.id("c1"))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	got = make([]string, 0)
+	for _, r := range replies {
+		got = append(got, r.CommentID)
+		if !r.CreatedTime.Equal(created.Add(time.Duration(len(got)) * time.Minute)) {
+			f.t.Errorf("reply %s created %s", r.CommentID, r.CreatedTime)
+		}
+	}
+	f.check("comment replies", f.names(got), []string{"c2", "c3"})
+}
+
+func testDeleted(f *fixture) {
+	post := f.post()
+	c1, _ := f.comment(post, "c1", "u", "", 0, "kept")
+	f.comment(post, "c2", "u", "", 1, "deleted")
+	f.comment(post, "c3", "u", "c1", 2, "deleted reply")
+
+	deleted, err := f.store.MarkDeletedComments("post", post, []string{c1.ID})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("deleted from post", f.names(deleted), []string{"c2"})
+
+	deleted, err = f.store.MarkDeletedComments("post", post, []string{c1.ID})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("deleted again", f.names(deleted), []string{})
+
+	deleted, err = f.store.MarkDeletedComments("comment", c1.ID, nil)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("deleted replies", f.names(deleted), []string{"c3"})
+
+	if _, err := f.store.MarkDeletedComments("page", f.id("page"), nil); err == nil {
+		f.t.Error("MarkDeletedComments() accepted a page")
+	}
+}
+
+func testScheduling(f *fixture) {
+	page := f.id("page")
+	post := f.post()
+
+	due := func() []string {
+		entries, err := f.store.GetSchedulerPosts(60)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		more, err := f.store.GetSchedulerComments(60, 0)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		got := make([]string, 0)
+		for _, e := range append(entries, more...) {
+			if e.ObjectID == page || e.ObjectID == page+"_"+post {
+				got = append(got, e.ObjectType)
+			}
+		}
+		sort.Strings(got)
+		return got
+	}
+	f.check("never checked", due(), []string{"page", "post"})
+
+	if err := f.store.SetScheduled("post", page+"_"+post); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.store.SetScheduled("page", page); err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("scheduled", due(), []string{})
+
+	if err := f.store.UpdateLastCheck("page", page, time.Now().Add(-2*time.Minute)); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.store.UpdateLastCheck("post", page+"_"+post, time.Now()); err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("checked", due(), []string{"page"})
+
+	for _, entryType := range []string{"user", "pages; DROP TABLE pages"} {
+		if err := f.store.SetScheduled(entryType, page); err == nil {
+			f.t.Errorf("SetScheduled() accepted type %q", entryType)
+		}
+		if err := f.store.UpdateLastCheck(entryType, page, time.Now()); err == nil {
+			f.t.Errorf("UpdateLastCheck() accepted type %q", entryType)
+		}
+	}
+	if err := f.store.SetScheduled("page", page+"' OR '1'='1"); err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("after scheduling an unknown ID", due(), []string{"page"})
+}
+
+func testCampaigns(f *fixture) {
+	post := f.post()
+	c1, _ := f.comment(post, "c1", "u1", "", 0, "vote for them")
+	c2, _ := f.comment(post, "c2", "u2", "", 1, "vote for them")
+	c3, _ := f.comment(post, "c3", "u3", "", 2, "vote for them!")
+
+	first, err := f.store.AddToCampaign(c2.ID, c1.ID, c2.Message, 1)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	second, err := f.store.AddToCampaign(c3.ID, c1.ID, c3.Message, 0.9)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.check("campaign of the third comment", second.ID, first.ID)
+
+	members, err := f.store.GetCampaignMembers(first.ID)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, m := range members {
+		got = append(got, m.CommentID)
+	}
+	f.check("members", f.names(got), []string{"c1", "c2", "c3"})
+}
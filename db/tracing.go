@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/moensch/fbbotscan/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// Same database, with its queries traced as part of ctx
+func (db *DB) WithContext(ctx context.Context) Store {
+	traced := *db
+	traced.ctx = ctx
+	return &traced
+}
+
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := db.startSpan(db.context(), query)
+	rows, err := db.Conn.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// The span ends before Scan, query errors only show up there
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := db.startSpan(db.context(), query)
+	row := db.Conn.QueryRowContext(ctx, query, args...)
+	span.End()
+	return row
+}
+
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := db.startSpan(db.context(), query)
+	res, err := db.Conn.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+func (db *DB) begin() (*tracedTx, error) {
+	tx, err := db.Conn.BeginTx(db.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, db: db}, nil
+}
+
+// Transaction tracing its statements
+type tracedTx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *tracedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := tx.db.startSpan(tx.db.context(), query)
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+func (tx *tracedTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := tx.db.startSpan(tx.db.context(), query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	span.End()
+	return row
+}
+
+// Spans are named after the statement, e.g. "db SELECT"
+func (db *DB) startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := "query"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	return tracing.Start(ctx, "db "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", db.Config.Type),
+			attribute.String("db.query.text", query),
+		),
+	)
+}
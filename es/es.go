@@ -1,10 +1,13 @@
 package es
 
 import (
+	"context"
 	"fmt"
 	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/tracing"
 	"gopkg.in/olivere/elastic.v5"
 	"log"
+	"net/http"
 )
 
 type ES struct {
@@ -38,6 +41,7 @@ func (es *ES) Initialize() error {
 	*/
 	es.Client, err = elastic.NewClient(
 		elastic.SetURL(fmt.Sprintf("%s", es.Config.URL)),
+		elastic.SetHttpClient(&http.Client{Transport: tracing.Transport(nil)}),
 	)
 
 	if err != nil {
@@ -46,3 +50,15 @@ func (es *ES) Initialize() error {
 
 	return nil
 }
+
+// Check the cluster answers and isn't red, for health checks
+func (es *ES) Health(ctx context.Context) error {
+	res, err := es.Client.ClusterHealth().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot get cluster health: %s", err)
+	}
+	if res.Status == "red" {
+		return fmt.Errorf("Cluster %s is red", res.ClusterName)
+	}
+	return nil
+}
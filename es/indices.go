@@ -0,0 +1,243 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Comments are stored in daily indices: fbcomments-YYYY.MM.DD
+	CommentIndexPrefix = "fbcomments"
+	// Points at the index new comments are written to
+	CommentWriteAlias = "fbcomments-write"
+	// Covers all comment indices
+	CommentSearchAlias = "fbcomments-search"
+
+	indexDateFormat = "2006.01.02"
+)
+
+// Install (or update) the template applied to every new comment index
+func (es *ES) PutCommentTemplate(ctx context.Context) error {
+	body := fmt.Sprintf(fbbot.CommentTemplate,
+		CommentIndexPrefix+"-*",
+		es.Config.Shards,
+		es.Config.Replicas,
+		CommentSearchAlias,
+		fbbot.CommentMappingVersion,
+	)
+
+	if _, err := es.Client.IndexPutTemplate(CommentIndexPrefix).BodyString(body).Do(ctx); err != nil {
+		return fmt.Errorf("Cannot install index template %s: %s", CommentIndexPrefix, err)
+	}
+	log.Infof("Installed index template %s", CommentIndexPrefix)
+
+	return nil
+}
+
+// Routes documents into daily indices by their own timestamp,
+//  creating indices on first use. Indices seen before are cached.
+type DailyIndices struct {
+	es         *ES
+	prefix     string
+	writeAlias string
+
+	mu     sync.Mutex
+	known  map[string]bool
+	newest string
+}
+
+func (es *ES) NewDailyIndices(prefix string, writeAlias string) *DailyIndices {
+	return &DailyIndices{
+		es:         es,
+		prefix:     prefix,
+		writeAlias: writeAlias,
+		known:      make(map[string]bool),
+	}
+}
+
+// Daily indices for comments
+func (es *ES) CommentIndices() *DailyIndices {
+	return es.NewDailyIndices(CommentIndexPrefix, CommentWriteAlias)
+}
+
+// Name of the index holding documents from the given time
+func (d *DailyIndices) Name(t time.Time) string {
+	return fmt.Sprintf("%s-%s", d.prefix, t.UTC().Format(indexDateFormat))
+}
+
+// Name of the index for the given time, creating it if needed. The
+//  write alias follows the newest index.
+func (d *DailyIndices) For(ctx context.Context, t time.Time) (string, error) {
+	name := d.Name(t)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.known[name] {
+		return name, nil
+	}
+
+	if err := d.es.createIndex(ctx, name); err != nil {
+		return name, err
+	}
+
+	if name > d.newest {
+		if err := d.es.advanceAlias(ctx, d.writeAlias, name); err != nil {
+			return name, err
+		}
+		d.newest = name
+	}
+
+	d.known[name] = true
+	return name, nil
+}
+
+// Names of the indices covering the given time up to today
+func (d *DailyIndices) Since(t time.Time) []string {
+	today := d.Name(time.Now())
+	names := make([]string, 0)
+	for day := t; ; day = day.AddDate(0, 0, 1) {
+		name := d.Name(day)
+		names = append(names, name)
+		if name >= today {
+			break
+		}
+	}
+	return names
+}
+
+// Create an index unless it exists. Settings, mappings and the
+//  search alias come from the template.
+func (es *ES) createIndex(ctx context.Context, name string) error {
+	exists, err := es.Client.IndexExists(name).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("IndexExists(%s) error: %s", name, err)
+	}
+
+	if !exists {
+		if _, err := es.Client.CreateIndex(name).Do(ctx); err != nil {
+			// Another storer may have been faster
+			if exists, _ := es.Client.IndexExists(name).Do(ctx); exists {
+				return nil
+			}
+			return fmt.Errorf("Cannot create ES index %s: %s", name, err)
+		}
+		log.Infof("Created new index %s", name)
+	}
+
+	return nil
+}
+
+// Point an alias at exactly one index, unless it already points
+//  at a newer one (index names sort by day)
+func (es *ES) advanceAlias(ctx context.Context, alias string, index string) error {
+	res, err := es.Client.Aliases().Index("_all").Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot load aliases: %s", err)
+	}
+
+	current := res.IndicesByAlias(alias)
+	if len(current) == 1 && current[0] >= index {
+		return nil
+	}
+
+	action := es.Client.Alias().Add(index, alias)
+	for _, old := range current {
+		if old != index {
+			action = action.Remove(old, alias)
+		}
+	}
+
+	if _, err := action.Do(ctx); err != nil {
+		return fmt.Errorf("Cannot point alias %s to %s: %s", alias, index, err)
+	}
+	log.Infof("Alias %s now points to %s (was: %s)", alias, index, strings.Join(current, ", "))
+
+	return nil
+}
+
+// Close or delete comment indices holding days older than maxAge.
+//  Closed indices are taken out of the search alias first. Returns
+//  the indices which were expired.
+func (es *ES) ExpireCommentIndices(ctx context.Context, maxAge time.Duration, action string) ([]string, error) {
+	if action != "close" && action != "delete" {
+		return nil, fmt.Errorf("Invalid retention action '%s' - must be one of 'close' or 'delete'", action)
+	}
+
+	names, err := es.Client.IndexNames()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list indices: %s", err)
+	}
+
+	res, err := es.Client.Aliases().Index("_all").Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load aliases: %s", err)
+	}
+	writing := make(map[string]bool)
+	for _, name := range res.IndicesByAlias(CommentWriteAlias) {
+		writing[name] = true
+	}
+	searching := make(map[string]bool)
+	for _, name := range res.IndicesByAlias(CommentSearchAlias) {
+		searching[name] = true
+	}
+
+	cutoff := time.Now().UTC().Add(-maxAge)
+	expired := make([]string, 0)
+	for _, name := range names {
+		day, ok := commentIndexDay(name)
+		if !ok {
+			continue
+		}
+
+		// Only expire indices whose whole day is past the cutoff
+		if !day.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+
+		if writing[name] {
+			log.Warnf("Not expiring %s, it is the current write index", name)
+			continue
+		}
+
+		switch action {
+		case "close":
+			// Searches through the alias fail on closed indices
+			if searching[name] {
+				if _, err := es.Client.Alias().Remove(name, CommentSearchAlias).Do(ctx); err != nil {
+					return expired, fmt.Errorf("Cannot remove %s from alias %s: %s", name, CommentSearchAlias, err)
+				}
+			}
+			_, err = es.Client.CloseIndex(name).Do(ctx)
+		case "delete":
+			_, err = es.Client.DeleteIndex(name).Do(ctx)
+		}
+		if err != nil {
+			return expired, fmt.Errorf("Cannot %s index %s: %s", action, name, err)
+		}
+
+		log.Infof("Expired index %s (%s)", name, action)
+		expired = append(expired, name)
+	}
+
+	return expired, nil
+}
+
+// Parse the day out of a comment index name
+func commentIndexDay(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, CommentIndexPrefix+"-") {
+		return time.Time{}, false
+	}
+
+	day, err := time.Parse(indexDateFormat, strings.TrimPrefix(name, CommentIndexPrefix+"-"))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return day, true
+}
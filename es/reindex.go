@@ -0,0 +1,303 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/lang"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+	"io"
+	"sort"
+)
+
+// Fills in fields older documents were stored without. The page_id
+//  isn't part of the comment ID, backfillPages looks it up.
+const reindexScript = `
+if (ctx._source.post_id == null && ctx._source.id != null) {
+    int i = ctx._source.id.indexOf('_');
+    if (i > 0) {
+        ctx._source.post_id = ctx._source.id.substring(0, i);
+    }
+}`
+
+// Comment indices whose mapping is older than CommentMappingVersion
+func (es *ES) OutdatedCommentIndices(ctx context.Context) ([]string, error) {
+	names, err := es.Client.IndexNames()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list indices: %s", err)
+	}
+	sort.Strings(names)
+
+	outdated := make([]string, 0)
+	for _, name := range names {
+		if _, ok := commentIndexDay(name); !ok {
+			continue
+		}
+
+		version, err := es.commentMappingVersion(ctx, name)
+		if err != nil {
+			return outdated, err
+		}
+		if version < fbbot.CommentMappingVersion {
+			outdated = append(outdated, name)
+		}
+	}
+
+	return outdated, nil
+}
+
+// Read _meta.version off an index' comment mapping. Indices created
+//  before the mapping was versioned report 0.
+func (es *ES) commentMappingVersion(ctx context.Context, name string) (int, error) {
+	res, err := es.Client.GetMapping().Index(name).Type("fbcomment").Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot load mapping of %s: %s", name, err)
+	}
+
+	// {"<index>": {"mappings": {"fbcomment": {"_meta": {"version": N}}}}}
+	version := 0
+	if index, ok := res[name].(map[string]interface{}); ok {
+		if mappings, ok := index["mappings"].(map[string]interface{}); ok {
+			if mapping, ok := mappings["fbcomment"].(map[string]interface{}); ok {
+				if meta, ok := mapping["_meta"].(map[string]interface{}); ok {
+					if v, ok := meta["version"].(float64); ok {
+						version = int(v)
+					}
+				}
+			}
+		}
+	}
+
+	return version, nil
+}
+
+// Returns the page a post belongs to
+type PageLookup func(post_id string) (string, error)
+
+// Rebuild a comment index with the current template. Documents are
+//  copied to a temporary index, the original is recreated and the
+//  documents are copied back, then documents stored without a
+//  language or page_id get one. Neither copy is deleted before the
+//  other one holds as many documents. Nothing may write to the index
+//  meanwhile.
+func (es *ES) ReindexCommentIndex(ctx context.Context, name string, pageOf PageLookup) error {
+	tmp := name + "-reindex"
+
+	res, err := es.Client.Aliases().Index("_all").Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot load aliases: %s", err)
+	}
+	writing := false
+	for _, index := range res.IndicesByAlias(CommentWriteAlias) {
+		if index == name {
+			writing = true
+		}
+	}
+
+	// The temporary index matches the template, keep it out of searches
+	if err := es.createIndex(ctx, tmp); err != nil {
+		return err
+	}
+	if _, err := es.Client.Alias().Remove(tmp, CommentSearchAlias).Do(ctx); err != nil {
+		return fmt.Errorf("Cannot remove %s from alias %s: %s", tmp, CommentSearchAlias, err)
+	}
+
+	if err := es.copyIndex(ctx, name, tmp); err != nil {
+		return err
+	}
+
+	if _, err := es.Client.DeleteIndex(name).Do(ctx); err != nil {
+		return fmt.Errorf("Cannot delete index %s: %s", name, err)
+	}
+	if err := es.createIndex(ctx, name); err != nil {
+		return err
+	}
+
+	if err := es.copyIndex(ctx, tmp, name); err != nil {
+		return fmt.Errorf("%s - documents remain in %s", err, tmp)
+	}
+
+	if err := es.detectLanguages(ctx, name); err != nil {
+		return err
+	}
+	if err := es.backfillPages(ctx, name, pageOf); err != nil {
+		return err
+	}
+
+	if _, err := es.Client.DeleteIndex(tmp).Do(ctx); err != nil {
+		return fmt.Errorf("Cannot delete index %s: %s", tmp, err)
+	}
+
+	if writing {
+		if err := es.advanceAlias(ctx, CommentWriteAlias, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Copy all documents of src into dst, which must end up holding as
+//  many documents as src
+func (es *ES) copyIndex(ctx context.Context, src string, dst string) error {
+	res, err := es.Client.Reindex().
+		SourceIndex(src).
+		DestinationIndex(dst).
+		Script(elastic.NewScript(reindexScript)).
+		Refresh("true").
+		WaitForCompletion(true).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot reindex %s into %s: %s", src, dst, err)
+	}
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("Cannot reindex %s into %s: %d documents failed", src, dst, len(res.Failures))
+	}
+
+	want, err := es.Client.Count(src).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot count documents in %s: %s", src, err)
+	}
+	got, err := es.Client.Count(dst).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot count documents in %s: %s", dst, err)
+	}
+	if got != want {
+		return fmt.Errorf("Cannot reindex %s into %s: %d of %d documents copied", src, dst, got, want)
+	}
+	log.Infof("Copied %d documents from %s to %s", res.Total, src, dst)
+
+	return nil
+}
+
+// Set the language of documents stored without one. Those whose
+//  language can't be told are left alone.
+func (es *ES) detectLanguages(ctx context.Context, name string) error {
+	type doc struct {
+		Message string `json:"message"`
+	}
+
+	scroll := es.Client.Scroll(name).
+		Query(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("language"))).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("message")).
+		Size(1000)
+	defer scroll.Clear(ctx)
+
+	detected := 0
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot scroll %s: %s", name, err)
+		}
+
+		bulk := es.Client.Bulk()
+		for _, hit := range res.Hits.Hits {
+			var d doc
+			if err := json.Unmarshal(*hit.Source, &d); err != nil {
+				return fmt.Errorf("Cannot deserialize search result: %s", err)
+			}
+
+			language := lang.Detect(d.Message)
+			if language == lang.Unknown {
+				continue
+			}
+			bulk.Add(elastic.NewBulkUpdateRequest().
+				Index(name).
+				Type("fbcomment").
+				Id(hit.Id).
+				Doc(map[string]interface{}{
+					"language": language,
+				}))
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+
+		detected += bulk.NumberOfActions()
+		updated, err := bulk.Refresh("true").Do(ctx)
+		if err != nil {
+			return fmt.Errorf("Cannot set languages in %s: %s", name, err)
+		}
+		if failed := updated.Failed(); len(failed) > 0 {
+			return fmt.Errorf("Cannot set languages in %s: %d documents failed", name, len(failed))
+		}
+	}
+	log.Infof("Detected the language of %d documents in %s", detected, name)
+
+	return nil
+}
+
+// Set the page_id of documents stored without one from the post they
+//  belong to. Those whose post is unknown are left alone.
+func (es *ES) backfillPages(ctx context.Context, name string, pageOf PageLookup) error {
+	type doc struct {
+		PostID string `json:"post_id"`
+	}
+
+	scroll := es.Client.Scroll(name).
+		Query(elastic.NewBoolQuery().
+			Must(elastic.NewExistsQuery("post_id")).
+			MustNot(elastic.NewExistsQuery("page_id"))).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("post_id")).
+		Size(1000)
+	defer scroll.Clear(ctx)
+
+	pages := make(map[string]string)
+	filled := 0
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot scroll %s: %s", name, err)
+		}
+
+		bulk := es.Client.Bulk()
+		for _, hit := range res.Hits.Hits {
+			var d doc
+			if err := json.Unmarshal(*hit.Source, &d); err != nil {
+				return fmt.Errorf("Cannot deserialize search result: %s", err)
+			}
+
+			page_id, ok := pages[d.PostID]
+			if !ok {
+				page_id, err = pageOf(d.PostID)
+				if err != nil {
+					log.Debugf("No page for comment %s: %s", hit.Id, err)
+				}
+				pages[d.PostID] = page_id
+			}
+			if page_id == "" {
+				continue
+			}
+			bulk.Add(elastic.NewBulkUpdateRequest().
+				Index(name).
+				Type("fbcomment").
+				Id(hit.Id).
+				Doc(map[string]interface{}{
+					"page_id": page_id,
+				}))
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+
+		filled += bulk.NumberOfActions()
+		updated, err := bulk.Refresh("true").Do(ctx)
+		if err != nil {
+			return fmt.Errorf("Cannot set pages in %s: %s", name, err)
+		}
+		if failed := updated.Failed(); len(failed) > 0 {
+			return fmt.Errorf("Cannot set pages in %s: %d documents failed", name, len(failed))
+		}
+	}
+	log.Infof("Filled in the page of %d documents in %s", filled, name)
+
+	return nil
+}
@@ -1,11 +1,18 @@
 package fbbotscan
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
-	fb "github.com/huandu/facebook"
+	fb "github.com/huandu/facebook/v2"
 	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/secrets"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"strconv"
+	"time"
 )
 
 type FBApp struct {
@@ -27,6 +34,18 @@ func New(configPath string) *FBApp {
 	return fbapp
 }
 
+// App for an already loaded configuration
+func NewFromConfig(cfg *config.Config) (*FBApp, error) {
+	fbapp := &FBApp{
+		Config: cfg,
+	}
+	if err := fbapp.Initialize(); err != nil {
+		return nil, err
+	}
+
+	return fbapp, nil
+}
+
 func (a *FBApp) LoadConfig() error {
 	var err error
 	a.Config, err = config.LoadFile(a.ConfigPath)
@@ -35,28 +54,51 @@ func (a *FBApp) LoadConfig() error {
 
 func (a *FBApp) Initialize() error {
 	var err error
-	if err := a.LoadConfig(); err != nil {
+	if a.Config == nil {
+		if err := a.LoadConfig(); err != nil {
+			return err
+		}
+	}
+	if err := a.Config.FB.Validate(); err != nil {
 		return err
 	}
 	log.Infof("Application ID: %s", a.Config.FB.AppID)
-	log.Infof("Application Secret: %s", a.Config.FB.AppSecret)
 	a.App = fb.New(a.Config.FB.AppID, a.Config.FB.AppSecret)
 	a.AppToken = a.App.AppAccessToken()
-	log.Infof("Application Token: %s", a.AppToken)
+	secrets.Register(a.AppToken)
 
 	a.Session = a.App.Session(a.AppToken)
-	a.Session.SetDebug(fb.DEBUG_ALL)
+	a.Session.SetDebug(fb.DebugMode(a.Config.FB.GraphDebug))
 
 	return err
 }
 
-func (a *FBApp) LoadFeed(pageId string, maxEntries int, since int64) ([]FBPost, error) {
+// Check the Graph API still accepts our token, for health checks.
+//  App tokens have no /me, so this looks up the app itself.
+func (a *FBApp) CheckToken(ctx context.Context) error {
+	started := time.Now()
+	res, err := a.Session.Get(fmt.Sprintf("/%s", a.Config.FB.AppID), fb.Params{"fields": "id"})
+	observeGraph(ctx, "app", started, err)
+	if err != nil {
+		return fmt.Errorf("Graph token is invalid: %s", err)
+	}
+
+	var id string
+	if err := res.DecodeField("id", &id); err != nil || id != a.Config.FB.AppID {
+		return fmt.Errorf("Graph token is not one of app %s", a.Config.FB.AppID)
+	}
+	return nil
+}
+
+func (a *FBApp) LoadFeed(ctx context.Context, pageId string, maxEntries int, since GraphTime) ([]FBPost, error) {
 	var err error
 
 	var posts = make([]FBPost, 0)
 
-	log.Infof("Loading feed for %s since %d", pageId, since)
-	res, err := a.Session.Get(fmt.Sprintf("/%s/feed", pageId), fb.Params{"limit": "4", "fields": "id,created_time,permalink_url,link,message,story", "since": since})
+	log.Infof("Loading feed for %s since %s", pageId, since)
+	started := time.Now()
+	res, err := a.Session.Get(fmt.Sprintf("/%s/feed", pageId), fb.Params{"limit": "4", "fields": "id,created_time,permalink_url,link,message,story", "since": since.Since()})
+	observeGraph(ctx, "feed", started, err)
 	if err != nil {
 		return posts, err
 	}
@@ -81,7 +123,9 @@ func (a *FBApp) LoadFeed(pageId string, maxEntries int, since int64) ([]FBPost,
 				return posts, err
 			}
 		}
+		started := time.Now()
 		noMore, err := p.Next()
+		observeGraph(ctx, "feed", started, err)
 		if err != nil {
 			return posts, errors.New(fmt.Sprintf("Error whilst calling Next() on paginaation: %s", err))
 		}
@@ -93,13 +137,15 @@ func (a *FBApp) LoadFeed(pageId string, maxEntries int, since int64) ([]FBPost,
 	return posts, err
 }
 
-func (a *FBApp) LoadComments(objectId string, since int64) ([]FBComment, error) {
+func (a *FBApp) LoadComments(ctx context.Context, objectId string, since GraphTime) ([]FBComment, error) {
 	var err error
 
 	var comments = make([]FBComment, 0)
 
-	log.Infof("Loading comments for %s since %d", objectId, since)
-	res, err := a.Session.Get(fmt.Sprintf("/%s/comments", objectId), fb.Params{"limit": "20", "order": "chronological", "fields": "id,created_time,from,message,parent,comment_count,like_count,permalink_url", "since": since})
+	log.Infof("Loading comments for %s since %s", objectId, since)
+	started := time.Now()
+	res, err := a.Session.Get(fmt.Sprintf("/%s/comments", objectId), fb.Params{"limit": "20", "order": "chronological", "fields": "id,created_time,from,message,parent,comment_count,like_count,permalink_url", "since": since.Since()})
+	observeGraph(ctx, "comments", started, err)
 	if err != nil {
 		return comments, err
 	}
@@ -121,7 +167,9 @@ func (a *FBApp) LoadComments(objectId string, since int64) ([]FBComment, error)
 			comments = append(comments, comment)
 			totalPosts++
 		}
+		started := time.Now()
 		noMore, err := p.Next()
+		observeGraph(ctx, "comments", started, err)
 		if err != nil {
 			return comments, errors.New(fmt.Sprintf("Error whilst calling Next() on paginaation: %s", err))
 		}
@@ -132,3 +180,19 @@ func (a *FBApp) LoadComments(objectId string, since int64) ([]FBComment, error)
 
 	return comments, err
 }
+
+// Count, time and trace a Graph API request, errors by their Graph
+//  error code
+func observeGraph(ctx context.Context, call string, started time.Time, err error) {
+	tracing.Record(ctx, "graph "+call, started, err, attribute.String("fb.graph.call", call))
+
+	metrics.GraphCalls.WithLabelValues(call).Inc()
+	metrics.GraphLatency.WithLabelValues(call).Observe(time.Since(started).Seconds())
+	if err != nil {
+		code := 0
+		if fberr, ok := err.(*fb.Error); ok {
+			code = fberr.Code
+		}
+		metrics.GraphErrors.WithLabelValues(call, strconv.Itoa(code)).Inc()
+	}
+}
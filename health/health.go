@@ -0,0 +1,115 @@
+// Package health keeps the dependency checks of a service and serves
+// them on /healthz and /readyz.
+//
+// /healthz only runs liveness checks, which fail when the process is
+// stuck and has to be restarted, like a closed AMQP channel. /readyz
+// runs those and the readiness checks, which fail while a dependency
+// such as the database or ElasticSearch is unreachable.
+package health
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Each check gets this long to answer
+const checkTimeout = 5 * time.Second
+
+// Returns nil if the dependency is fine
+type Check func(ctx context.Context) error
+
+var (
+	mu    sync.Mutex
+	live  = make(map[string]Check)
+	ready = make(map[string]Check)
+)
+
+// Add a check to /healthz and /readyz
+func Live(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	live[name] = check
+}
+
+// Add a check to /readyz
+func Ready(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	ready[name] = check
+}
+
+// Remember the result of an expensive or rate limited check for ttl
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var last time.Time
+	var result error
+
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) > ttl {
+			result = check(ctx)
+			last = time.Now()
+		}
+		return result
+	}
+}
+
+// Add the /healthz and /readyz handlers to mux
+func Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, true)
+	})
+}
+
+// Run the checks and answer 200 if all passed, 503 otherwise, with
+//  one line per check
+func serve(w http.ResponseWriter, r *http.Request, readiness bool) {
+	checks := make(map[string]Check)
+	mu.Lock()
+	for name, check := range live {
+		checks[name] = check
+	}
+	if readiness {
+		for name, check := range ready {
+			checks[name] = check
+		}
+	}
+	mu.Unlock()
+
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := http.StatusOK
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := checks[name](ctx)
+		cancel()
+
+		if err != nil {
+			log.Warnf("Health check %s failed: %s", name, err)
+			status = http.StatusServiceUnavailable
+			lines = append(lines, fmt.Sprintf("%s: %s", name, err))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: ok", name))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
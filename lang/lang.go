@@ -0,0 +1,110 @@
+// Package lang identifies the language of a comment from the stopwords
+// and letters it uses. It only knows the languages of the pages we
+// monitor and needs no external data or services.
+package lang
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	Unknown = ""
+	English = "en"
+	German  = "de"
+	Spanish = "es"
+)
+
+// Supported languages, in order of preference when scores are tied
+var Languages = []string{English, German, Spanish}
+
+// A language needs at least this many hits to be detected
+const minHits = 2
+
+// ElasticSearch analyzer for each language, the comment template
+//  has a message sub-field of the same name
+var analyzers = map[string]string{
+	English: "english",
+	German:  "german",
+	Spanish: "spanish",
+}
+
+// Common words which are (nearly) exclusive to one of the languages.
+//  Words shared between them, like "in", "no" or "es", are left out.
+var stopwords = map[string]string{}
+
+func init() {
+	for language, words := range map[string][]string{
+		English: strings.Fields(`the and is are you that this it of to not with for have be
+			they he she we what but on at by from just all my your will would can do does
+			there their people if or about like more who get has been how why our should
+			because them these those than any were which when`),
+		German: strings.Fields(`der die das und ist nicht ich sie er wir ihr den dem des ein
+			eine einen zu mit auf für von auch sich noch nur wie wenn aber oder sind hat
+			haben wird werden kein keine mehr schon doch dass diese dieser immer bei nach
+			uns mal jetzt hier alle über sehr leute`),
+		Spanish: strings.Fields(`el la los las que y en del por con para una un se lo su pero
+			más como muy todo todos este esta son está ya porque hay cuando también nos sin
+			sobre ser gente al mi sus le les eso esto hace`),
+	} {
+		for _, word := range words {
+			stopwords[word] = language
+		}
+	}
+}
+
+// Detect the language of a text, Unknown if it is too short or too
+//  mixed to tell. The best language needs at least twice the hits of
+//  the runner-up.
+func Detect(text string) string {
+	hits := make(map[string]int)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		if language, ok := stopwords[word]; ok {
+			hits[language]++
+			continue
+		}
+		if language := letters(word); language != Unknown {
+			hits[language]++
+		}
+	}
+	// Spanish questions and exclamations open with inverted marks
+	hits[Spanish] += strings.Count(text, "¿") + strings.Count(text, "¡")
+
+	best, second := Unknown, 0
+	for _, language := range Languages {
+		switch {
+		case best == Unknown || hits[language] > hits[best]:
+			if best != Unknown {
+				second = hits[best]
+			}
+			best = language
+		case hits[language] > second:
+			second = hits[language]
+		}
+	}
+
+	if hits[best] < minHits || hits[best] < 2*second {
+		return Unknown
+	}
+	return best
+}
+
+// Language given away by the letters of a word
+func letters(word string) string {
+	if strings.ContainsAny(word, "äöüß") {
+		return German
+	}
+	if strings.ContainsAny(word, "ñáéíóú") {
+		return Spanish
+	}
+	return Unknown
+}
+
+// ElasticSearch analyzer for a language, empty if it has none
+func Analyzer(language string) string {
+	return analyzers[language]
+}
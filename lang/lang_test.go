@@ -0,0 +1,54 @@
+package lang
+
+import (
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "They should be ashamed of what they did to the people", English},
+		{"german", "Das ist doch nicht zu glauben, wir haben es immer gesagt", German},
+		{"spanish", "Esto es lo que pasa cuando nadie hace nada por la gente", Spanish},
+		{"german umlauts", "Schöne Grüße", German},
+		{"spanish accents", "Qué señor más simpático", Spanish},
+		{"spanish question marks", "¿Verdad? ¡Claro!", Spanish},
+		{"case insensitive", "THEY SHOULD BE ASHAMED OF THE PEOPLE", English},
+		{"empty", "", Unknown},
+		{"too short", "the", Unknown},
+		{"no stopwords", "Trump Merkel Macron", Unknown},
+		{"emoji only", "😂😂😂 👍", Unknown},
+		{"too mixed", "the and der die", Unknown},
+		{"clear winner despite a foreign word", "the people and the government are not ok, mal sehen", English},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.text); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{English, "english"},
+		{German, "german"},
+		{Spanish, "spanish"},
+		{Unknown, ""},
+		{"fr", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Analyzer(tt.language); got != tt.want {
+			t.Errorf("Analyzer(%q) = %q, want %q", tt.language, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package logging sets up the log output of the services and carries
+//  correlation IDs which follow one object from the scheduler through
+//  the fetcher to the storer and classifier.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+)
+
+// Field name of the correlation ID in structured log output
+const CorrelationField = "correlation_id"
+
+type correlationKey struct{}
+
+// Set the log level and switch to one JSON object per line if
+//  asked to
+func Setup(level string, json bool) {
+	lvl, _ := log.ParseLevel(level)
+	log.SetLevel(lvl)
+	if json {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+}
+
+// Random ID for a new unit of work
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Cannot generate correlation ID: %s", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Context carrying the correlation ID, a new one if id is empty
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = NewCorrelationID()
+	}
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// Correlation ID of ctx, empty if there is none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// Logger adding the correlation ID of ctx to every line
+func Entry(ctx context.Context) *log.Entry {
+	if id := CorrelationID(ctx); id != "" {
+		return log.WithField(CorrelationField, id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
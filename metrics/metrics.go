@@ -0,0 +1,146 @@
+// Package metrics holds the Prometheus metrics of all services and
+// serves them on /metrics, next to the health checks.
+package metrics
+
+import (
+	"github.com/moensch/fbbotscan/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const namespace = "fbbotscan"
+
+var (
+	// Scheduler
+	Scheduled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "objects_scheduled_total",
+		Help:      "Objects published to a fetch queue",
+	}, []string{"queue"})
+
+	// Fetcher
+	GraphCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "graph",
+		Name:      "calls_total",
+		Help:      "Graph API requests, including paging",
+	}, []string{"call"})
+	GraphErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "graph",
+		Name:      "errors_total",
+		Help:      "Failed Graph API requests by Graph error code, 0 if there was none",
+	}, []string{"call", "code"})
+	GraphLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "graph",
+		Name:      "call_duration_seconds",
+		Help:      "Graph API request latency",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"call"})
+	CommentsFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "comments_total",
+		Help:      "Comments fetched by what changed (new, edited, unchanged, deleted)",
+	}, []string{"change"})
+	PostsFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "posts_total",
+		Help:      "Posts fetched from page feeds",
+	})
+
+	// Storer
+	Indexed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storer",
+		Name:      "comments_total",
+		Help:      "Comments sent to ElasticSearch by outcome (stored, retried, rejected)",
+	}, []string{"status"})
+	IndexLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storer",
+		Name:      "bulk_duration_seconds",
+		Help:      "ElasticSearch bulk request latency",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	// Classifier
+	Classified = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "classifier",
+		Name:      "comments_total",
+		Help:      "Classified comments by band",
+	}, []string{"band"})
+	Signals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "classifier",
+		Name:      "signals_total",
+		Help:      "Detector signals by detector and band",
+	}, []string{"detector", "band"})
+
+	// Consumers
+	QueueLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "amqp",
+		Name:      "queue_lag_seconds",
+		Help:      "Time between publishing a message and its consumer receiving it",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"queue"})
+	Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "amqp",
+		Name:      "deliveries_total",
+		Help:      "Deliveries handled by outcome (acked, deferred, retried, requeued, dropped)",
+	}, []string{"queue", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		Scheduled,
+		GraphCalls,
+		GraphErrors,
+		GraphLatency,
+		CommentsFetched,
+		PostsFetched,
+		Indexed,
+		IndexLatency,
+		Classified,
+		Signals,
+		QueueLag,
+		Deliveries,
+	)
+}
+
+// Record how long a delivery waited in its queue, going by the
+//  timestamp it was published with
+func ObserveQueueLag(queue string, published time.Time) {
+	if published.IsZero() {
+		return
+	}
+	QueueLag.WithLabelValues(queue).Observe(time.Since(published).Seconds())
+}
+
+// Serve /metrics, /healthz and /readyz on addr in the background.
+//  Does nothing without an address.
+func Serve(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	health.Handle(mux)
+
+	go func() {
+		log.Infof("Serving metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Errorf("Metrics endpoint failed: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	fbbot "github.com/moensch/fbbotscan"
+	"sort"
+)
+
+// Label propagation rarely needs more rounds than this to settle
+const maxRounds = 20
+
+// Find communities with weighted label propagation: every author
+//  repeatedly takes the label with the most edge weight among its
+//  neighbours. Authors are visited in a fixed order and ties go to
+//  the smallest label, so the result is deterministic.
+//  Communities smaller than minSize or sparser than minDensity
+//  (share of possible edges present) are left out.
+func (g *Graph) Communities(minSize int, minDensity float64) []fbbot.Network {
+	if minSize < 2 {
+		minSize = 2
+	}
+
+	nodes := make([]string, 0, len(g.edges))
+	for node := range g.edges {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	labels := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		labels[node] = node
+	}
+
+	for round := 0; round < maxRounds; round++ {
+		changed := false
+		for _, node := range nodes {
+			weights := make(map[string]float64)
+			for neighbour, w := range g.edges[node] {
+				weights[labels[neighbour]] += w
+			}
+
+			best := labels[node]
+			for label, w := range weights {
+				if w > weights[best] || (w == weights[best] && label < best) {
+					best = label
+				}
+			}
+			if best != labels[node] {
+				labels[node] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	groups := make(map[string][]string)
+	for _, node := range nodes {
+		groups[labels[node]] = append(groups[labels[node]], node)
+	}
+
+	networks := make([]fbbot.Network, 0)
+	for _, members := range groups {
+		if len(members) < minSize {
+			continue
+		}
+
+		var edges int
+		var weight float64
+		for i := range members {
+			for j := i + 1; j < len(members); j++ {
+				if w := g.edges[members[i]][members[j]]; w > 0 {
+					edges++
+					weight += w
+				}
+			}
+		}
+		density := float64(edges) / float64(len(members)*(len(members)-1)/2)
+		if density < minDensity {
+			continue
+		}
+
+		networks = append(networks, fbbot.Network{
+			Members: members,
+			Density: density,
+			Weight:  weight,
+		})
+	}
+	sort.Sort(byWeight(networks))
+
+	return networks
+}
+
+type byWeight []fbbot.Network
+
+func (n byWeight) Len() int           { return len(n) }
+func (n byWeight) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byWeight) Less(i, j int) bool { return n[i].Weight > n[j].Weight }
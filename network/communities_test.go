@@ -0,0 +1,124 @@
+package network
+
+import (
+	fbbot "github.com/moensch/fbbotscan"
+	"reflect"
+	"testing"
+)
+
+// Graph with an edge of the given weight between every pair of
+//  authors in each group
+func cliques(weight float64, groups ...[]string) *Graph {
+	g := NewGraph()
+	for _, group := range groups {
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				g.add(group[i], group[j], weight)
+			}
+		}
+	}
+	return g
+}
+
+func members(networks []fbbot.Network) [][]string {
+	got := make([][]string, 0)
+	for _, n := range networks {
+		got = append(got, n.Members)
+	}
+	return got
+}
+
+func TestCommunities(t *testing.T) {
+	tests := []struct {
+		name       string
+		graph      func() *Graph
+		minSize    int
+		minDensity float64
+		want       [][]string
+	}{
+		{
+			name:    "empty graph",
+			graph:   NewGraph,
+			minSize: 3,
+			want:    [][]string{},
+		},
+		{
+			name:    "two separate cliques",
+			graph:   func() *Graph { return cliques(1, []string{"a", "b", "c"}, []string{"x", "y", "z"}) },
+			minSize: 3,
+			want:    [][]string{{"a", "b", "c"}, {"x", "y", "z"}},
+		},
+		{
+			name: "heavier community first",
+			graph: func() *Graph {
+				g := cliques(1, []string{"a", "b", "c"})
+				g.add("x", "y", 5)
+				g.add("y", "z", 5)
+				g.add("x", "z", 5)
+				return g
+			},
+			minSize: 3,
+			want:    [][]string{{"x", "y", "z"}, {"a", "b", "c"}},
+		},
+		{
+			name: "weak link between cliques",
+			graph: func() *Graph {
+				g := cliques(3, []string{"a", "b", "c", "d"}, []string{"w", "x", "y", "z"})
+				g.add("d", "w", 1)
+				return g
+			},
+			minSize: 3,
+			want:    [][]string{{"a", "b", "c", "d"}, {"w", "x", "y", "z"}},
+		},
+		{
+			name:    "too small",
+			graph:   func() *Graph { return cliques(1, []string{"a", "b"}, []string{"x", "y", "z"}) },
+			minSize: 3,
+			want:    [][]string{{"x", "y", "z"}},
+		},
+		{
+			name:    "pairs count with the minimum size of 2",
+			graph:   func() *Graph { return cliques(1, []string{"a", "b"}) },
+			minSize: 0,
+			want:    [][]string{{"a", "b"}},
+		},
+		{
+			name: "too sparse",
+			graph: func() *Graph {
+				// A star: 3 of 6 possible edges
+				g := NewGraph()
+				g.add("hub", "a", 1)
+				g.add("hub", "b", 1)
+				g.add("hub", "c", 1)
+				return g
+			},
+			minSize:    3,
+			minDensity: 0.6,
+			want:       [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := members(tt.graph().Communities(tt.minSize, tt.minDensity))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Communities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommunityDensityAndWeight(t *testing.T) {
+	g := cliques(2, []string{"a", "b", "c"})
+	g.add("c", "d", 2)
+
+	networks := g.Communities(4, 0)
+	if len(networks) != 1 {
+		t.Fatalf("Communities() = %v, want one network", networks)
+	}
+	n := networks[0]
+	// 4 of 6 possible edges, each of weight 2
+	if n.Density != 4.0/6 || n.Weight != 8 {
+		t.Errorf("density %v and weight %v, want %v and 8", n.Density, n.Weight, 4.0/6)
+	}
+}
@@ -0,0 +1,122 @@
+// Package network builds a graph of authors who comment together and
+// finds dense communities in it, which are likely coordinated.
+package network
+
+import (
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"sort"
+	"time"
+)
+
+// Campaigns bigger than this only link their first members, the
+//  number of pairs grows quadratically
+const maxCampaignMembers = 500
+
+// Undirected, weighted author graph
+type Graph struct {
+	edges map[string]map[string]float64
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		edges: make(map[string]map[string]float64),
+	}
+}
+
+func (g *Graph) add(a string, b string, weight float64) {
+	if a == b || a == "" || b == "" {
+		return
+	}
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		if g.edges[pair[0]] == nil {
+			g.edges[pair[0]] = make(map[string]float64)
+		}
+		g.edges[pair[0]][pair[1]] += weight
+	}
+}
+
+// Weight of the edge between two authors
+func (g *Graph) Weight(a string, b string) float64 {
+	return g.edges[a][b]
+}
+
+// Number of authors with at least one edge
+func (g *Graph) Len() int {
+	return len(g.edges)
+}
+
+// Link authors who commented on the same post within window of
+//  each other. Each post adds at most 1 to an edge.
+func (g *Graph) AddCoComments(comments []db.UserComment, window time.Duration) {
+	byPost := make(map[string][]db.UserComment)
+	for _, c := range comments {
+		byPost[c.PostID] = append(byPost[c.PostID], c)
+	}
+
+	for _, post := range byPost {
+		sort.Sort(byCreatedTime(post))
+
+		linked := make(map[[2]string]bool)
+		for i := range post {
+			for j := i + 1; j < len(post) && post[j].CreatedTime.Sub(post[i].CreatedTime) <= window; j++ {
+				a, b := post[i].UserID, post[j].UserID
+				if b < a {
+					a, b = b, a
+				}
+				if a == b || linked[[2]string{a, b}] {
+					continue
+				}
+				linked[[2]string{a, b}] = true
+				g.add(a, b, 1)
+			}
+		}
+	}
+}
+
+// Link all authors of a campaign of near-identical comments
+func (g *Graph) AddCampaign(members []fbbot.CampaignMember, weight float64) {
+	if len(members) > maxCampaignMembers {
+		members = members[:maxCampaignMembers]
+	}
+
+	authors := make(map[string]bool)
+	for _, m := range members {
+		authors[m.UserID] = true
+	}
+	ids := sortedKeys(authors)
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			g.add(ids[i], ids[j], weight)
+		}
+	}
+}
+
+// Drop edges lighter than minWeight and authors left without edges
+func (g *Graph) Prune(minWeight float64) {
+	for a, neighbours := range g.edges {
+		for b, w := range neighbours {
+			if w < minWeight {
+				delete(neighbours, b)
+			}
+		}
+		if len(neighbours) == 0 {
+			delete(g.edges, a)
+		}
+	}
+}
+
+type byCreatedTime []db.UserComment
+
+func (c byCreatedTime) Len() int           { return len(c) }
+func (c byCreatedTime) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byCreatedTime) Less(i, j int) bool { return c[i].CreatedTime.Before(c[j].CreatedTime) }
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
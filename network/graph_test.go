@@ -0,0 +1,121 @@
+package network
+
+import (
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2018, 3, 4, 12, 0, 0, 0, time.UTC)
+
+// Comment by user on post, seconds after start
+func comment(user string, post string, seconds int) db.UserComment {
+	return db.UserComment{
+		CommentID:   user + "_" + post,
+		UserID:      user,
+		PostID:      post,
+		CreatedTime: start.Add(time.Duration(seconds) * time.Second),
+	}
+}
+
+func TestAddCoComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		comments []db.UserComment
+		a, b     string
+		want     float64
+	}{
+		{
+			name:     "within the window",
+			comments: []db.UserComment{comment("a", "p1", 0), comment("b", "p1", 60)},
+			a:        "a",
+			b:        "b",
+			want:     1,
+		},
+		{
+			name:     "outside the window",
+			comments: []db.UserComment{comment("a", "p1", 0), comment("b", "p1", 600)},
+			a:        "a",
+			b:        "b",
+			want:     0,
+		},
+		{
+			name:     "once per post",
+			comments: []db.UserComment{comment("a", "p1", 0), comment("b", "p1", 10), comment("a", "p1", 20), comment("b", "p1", 30)},
+			a:        "a",
+			b:        "b",
+			want:     1,
+		},
+		{
+			name:     "once for each post",
+			comments: []db.UserComment{comment("a", "p1", 0), comment("b", "p1", 10), comment("b", "p2", 0), comment("a", "p2", 10)},
+			a:        "a",
+			b:        "b",
+			want:     2,
+		},
+		{
+			name:     "different posts",
+			comments: []db.UserComment{comment("a", "p1", 0), comment("b", "p2", 10)},
+			a:        "a",
+			b:        "b",
+			want:     0,
+		},
+		{
+			name:     "unsorted comments",
+			comments: []db.UserComment{comment("c", "p1", 1000), comment("a", "p1", 0), comment("b", "p1", 900)},
+			a:        "b",
+			b:        "c",
+			want:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph()
+			g.AddCoComments(tt.comments, 5*time.Minute)
+			if got := g.Weight(tt.a, tt.b); got != tt.want {
+				t.Errorf("Weight(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := g.Weight(tt.b, tt.a); got != tt.want {
+				t.Errorf("Weight(%s, %s) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddCampaign(t *testing.T) {
+	g := NewGraph()
+	g.AddCampaign([]fbbot.CampaignMember{
+		{CommentID: "1", UserID: "a"},
+		{CommentID: "2", UserID: "b"},
+		{CommentID: "3", UserID: "a"},
+		{CommentID: "4", UserID: "c"},
+	}, 2)
+
+	if g.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", g.Len())
+	}
+	for _, pair := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}} {
+		if w := g.Weight(pair[0], pair[1]); w != 2 {
+			t.Errorf("Weight(%s, %s) = %v, want 2", pair[0], pair[1], w)
+		}
+	}
+	if w := g.Weight("a", "a"); w != 0 {
+		t.Errorf("Weight(a, a) = %v, want no self edge", w)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	g := NewGraph()
+	g.add("a", "b", 2)
+	g.add("b", "c", 1)
+	g.Prune(2)
+
+	if g.Weight("a", "b") != 2 || g.Weight("b", "c") != 0 {
+		t.Errorf("Prune() kept the wrong edges: a-b %v, b-c %v", g.Weight("a", "b"), g.Weight("b", "c"))
+	}
+	if g.Len() != 2 {
+		t.Errorf("Len() = %d, want c dropped", g.Len())
+	}
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/classifier"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/health"
+	"github.com/moensch/fbbotscan/lang"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/tracing"
+	"github.com/streadway/amqp"
+)
+
+// Score fetched comments with the configured detectors and store the
+//  verdicts
+func StartClassifier(env *Env) (*pubsub.Consumer, error) {
+	// Setup ElasticSearch client
+	client := es.New(env.Config.ES)
+	health.Ready("elasticsearch", client.Health)
+
+	runner, err := classifier.New(context.Background(), &classifier.Env{
+		ES:     client,
+		Store:  env.Store,
+		Config: env.Config.Classify,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return env.consume(pubsub.ConsumerOptions{
+		Queue: "comments-classify",
+		Key:   authorKey,
+	}, func(ctx context.Context, d amqp.Delivery) error {
+		return classifyComment(ctx, d, runner, env.Store)
+	})
+}
+
+// Comments of an author are classified in order, the timing and
+//  reply detectors look at what they posted before
+func authorKey(d amqp.Delivery) string {
+	var entry fbbot.FBComment
+	json.Unmarshal(d.Body, &entry)
+	return entry.From.ID
+}
+
+func classifyComment(ctx context.Context, d amqp.Delivery, runner *classifier.Runner, appdb db.Store) error {
+	entry := fbbot.FBComment{}
+	if err := pubsub.Decode(d, &entry); err != nil {
+		return err
+	}
+	logger := logging.Entry(ctx).WithField("comment_id", entry.ID)
+
+	// Published before the fetcher detected languages
+	if entry.Language == lang.Unknown {
+		entry.Language = lang.Detect(entry.Message)
+	}
+
+	logger.Infof("Classify comment %s from %s (%s): %s", entry.ID, entry.From.ID, entry.Language, entry.Message)
+	result, err := runner.Run(ctx, &entry)
+	if err != nil {
+		// Retried or requeued by the consumer, nothing is stored yet
+		return err
+	}
+	logger.Infof("Comment %s scored %1.3f (%s) from %d signals", entry.ID, result.Score, result.Band, len(result.Signals))
+	metrics.Classified.WithLabelValues(result.Band).Inc()
+	for _, signal := range result.Signals {
+		metrics.Signals.WithLabelValues(signal.Detector, signal.Band).Inc()
+	}
+
+	for _, verdict := range result.Verdicts() {
+		if err := appdb.WithContext(ctx).InsertVerdict(verdict); err != nil {
+			return fmt.Errorf("Cannot store %s verdict of comment %s: %s", verdict.Method, entry.ID, err)
+		}
+	}
+
+	// Only new comments are traced from the time they were posted
+	if entry.Revision <= 1 && !entry.CreatedTime.IsZero() {
+		tracing.RecordVerdict(ctx, entry.ID, entry.CreatedTime.Time)
+	}
+
+	return nil
+}
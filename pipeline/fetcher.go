@@ -0,0 +1,238 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/lang"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"strings"
+	"time"
+)
+
+// Load what the scheduler published to queue (comments-fetch or
+//  posts-fetch) from the Graph API
+func StartFetcher(env *Env, queue string) (*pubsub.Consumer, error) {
+	app, err := env.fbApp()
+	if err != nil {
+		return nil, err
+	}
+
+	switch queue {
+	case "comments-fetch":
+		pub, err := env.Broker.Publisher("comments-store", "comments-classify")
+		if err != nil {
+			return nil, err
+		}
+		return env.consume(pubsub.ConsumerOptions{
+			Queue: queue,
+			Key:   objectKey,
+			Drained: func() {
+				if err := pub.Close(); err != nil {
+					log.Errorf("%s", err)
+				}
+			},
+		}, func(ctx context.Context, d amqp.Delivery) error {
+			return fetchComments(ctx, d, app, env.Store, pub)
+		})
+	case "posts-fetch":
+		return env.consume(pubsub.ConsumerOptions{
+			Queue: queue,
+			Key:   objectKey,
+		}, func(ctx context.Context, d amqp.Delivery) error {
+			return fetchPosts(ctx, d, app, env.Store)
+		})
+	}
+
+	return nil, fmt.Errorf("Unknown fetch queue %s", queue)
+}
+
+// Fetches of the same object are handled in order
+func objectKey(d amqp.Delivery) string {
+	var entry fbbot.QueueEntry
+	json.Unmarshal(d.Body, &entry)
+	return entry.ObjectID
+}
+
+// Comments of a delivery are all published before it is acked, so
+//  a shutdown doesn't leave comments stored but never indexed
+func fetchComments(ctx context.Context, d amqp.Delivery, app *fbbot.FBApp, appdb db.Store, pub pubsub.Publisher) error {
+	store := appdb.WithContext(ctx)
+
+	entry := fbbot.QueueEntry{}
+	if err := pubsub.Decode(d, &entry); err != nil {
+		return err
+	}
+	logger := logging.Entry(ctx).WithField("object_id", entry.ObjectID)
+
+	logger.Infof("Will fetch comments for: %s (type: %s / last checked: %s / full: %t)", entry.ObjectID, entry.ObjectType, entry.LastChecked, entry.Full)
+
+	// A full re-fetch loads all comments to spot edits and deletions
+	since := entry.LastChecked
+	if entry.Full {
+		since = fbbot.GraphTime{}
+	}
+
+	now := time.Now()
+	comments, err := app.LoadComments(ctx, entry.ObjectID, since)
+
+	// Extract post and page ID
+	var post_id, page_id string
+	if strings.Contains(entry.ObjectID, "_") {
+		id_parts := strings.Split(entry.ObjectID, "_")
+		switch entry.ObjectType {
+		case "comment":
+			// Comment IDs are "<post_id>_<comment_id>"
+			post_id = id_parts[0]
+		case "post":
+			// Post IDs are "<page_id>_<post_id>"
+			post_id = id_parts[1]
+			page_id = id_parts[0]
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve comments for %s: %s", entry.ObjectID, err)
+	}
+
+	if page_id == "" && post_id != "" {
+		if page_id, err = store.GetPostPage(post_id); err != nil {
+			logger.Warnf("Cannot find page of post %s: %s", post_id, err)
+		}
+	}
+
+	err = store.UpdateLastCheck(entry.ObjectType, entry.ObjectID, now)
+	if err != nil {
+		return fmt.Errorf("Failed to update last_check: %s", err)
+	}
+
+	seen := make([]string, 0, len(comments))
+	for _, comment := range comments {
+		logger.Infof("  Comment ID: %s (From: %s) / Parent: %s", comment.ID, comment.From.Name, comment.Parent.ID)
+		seen = append(seen, comment.ID)
+		comment.PageID = page_id
+		comment.PostID = post_id
+		comment.Language = lang.Detect(comment.Message)
+
+		// Store comment in database (metadata only)
+		change, err := store.UpsertComment(&comment, post_id)
+		upserted := err == nil
+		if err == nil {
+			metrics.CommentsFetched.WithLabelValues(change.String()).Inc()
+		}
+		if err != nil {
+			jsonblob, _ := json.Marshal(comment)
+			logger.Errorf("Insert failed: %s", err)
+			logger.Errorf("comment_id: %s / post_id: %s / parent_id: %s / from: %s", comment.ID, post_id, comment.Parent.ID, comment.From.ID)
+			logger.Errorf("%s", comment.PermalinkURL)
+			logger.Errorf("JSON: %s", string(jsonblob))
+		} else if change == db.CommentUnchanged {
+			logger.Debugf("  Comment %s unchanged", comment.ID)
+			continue
+		} else if change == db.CommentEdited {
+			logger.Infof("  Comment %s was edited (revision %d)", comment.ID, comment.Revision)
+		}
+
+		// New comments are traced from the time they were posted
+		created := comment.CreatedTime.Time
+		if change != db.CommentNew {
+			created = time.Time{}
+		}
+		commentCtx, commentSpan := tracing.StartComment(ctx, comment.ID, created)
+
+		// Publish full comment to the store and classify queues
+		err = pub.PublishJSON(commentCtx, "comments-store", comment)
+		if err == nil {
+			err = pub.PublishJSON(commentCtx, "comments-classify", comment)
+		}
+		tracing.End(commentSpan, err)
+		if err != nil {
+			// Still unpublished, UpsertComment reports it again when
+			//  the delivery is retried
+			return fmt.Errorf("Cannot publish comment %s: %s", comment.ID, err)
+		}
+		if upserted {
+			if err := store.SetCommentPublished(comment.ID, comment.Revision); err != nil {
+				return err
+			}
+		}
+	}
+
+	if entry.Full {
+		if err := markDeleted(ctx, store, pub, entry, post_id, seen, now); err != nil {
+			return fmt.Errorf("Failed to track deleted comments of %s: %s", entry.ObjectID, err)
+		}
+	}
+
+	logger.Infof("Successfully fetched comments for %s %s", entry.ObjectType, entry.ObjectID)
+
+	return nil
+}
+
+// Mark comments which disappeared from a full re-fetch as deleted
+//  and tell the storer about them
+func markDeleted(ctx context.Context, appdb db.Store, pub pubsub.Publisher, entry fbbot.QueueEntry, post_id string, seen []string, now time.Time) error {
+	deleted, err := appdb.MarkDeletedComments(entry.ObjectType, entry.ObjectID, seen)
+	if err != nil {
+		return err
+	}
+
+	metrics.CommentsFetched.WithLabelValues("deleted").Add(float64(len(deleted)))
+	for _, comment_id := range deleted {
+		logging.Entry(ctx).Infof("  Comment %s_%s was deleted", post_id, comment_id)
+		err := pub.PublishJSON(
+			ctx,
+			"comments-store",
+			fbbot.FBComment{
+				ID:          fmt.Sprintf("%s_%s", post_id, comment_id),
+				Deleted:     true,
+				DeletedTime: fbbot.NewGraphTime(now),
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("Cannot publish deleted comment: %s", err)
+		}
+	}
+
+	return appdb.UpdateLastFullCheck(entry.ObjectType, entry.ObjectID, now)
+}
+
+func fetchPosts(ctx context.Context, d amqp.Delivery, app *fbbot.FBApp, appdb db.Store) error {
+	store := appdb.WithContext(ctx)
+
+	entry := fbbot.QueueEntry{}
+	if err := pubsub.Decode(d, &entry); err != nil {
+		return err
+	}
+	logger := logging.Entry(ctx).WithField("object_id", entry.ObjectID)
+
+	logger.Infof("Will fetch feed for page %s (last checked: %s)", entry.ObjectID, entry.LastChecked)
+
+	now := time.Now()
+	posts, err := app.LoadFeed(ctx, entry.ObjectID, 5, entry.LastChecked)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve feed for %s: %s", entry.ObjectID, err)
+	}
+
+	err = store.UpdateLastCheck(entry.ObjectType, entry.ObjectID, now)
+	if err != nil {
+		return fmt.Errorf("Failed to update last_check: %s", err)
+	}
+
+	metrics.PostsFetched.Add(float64(len(posts)))
+	for _, post := range posts {
+		logger.Infof("Post ID: %s / Permalink: %s", post.ID, post.PermalinkURL)
+
+		if err := store.InsertPost(&post); err != nil {
+			logger.Errorf("Insert post failed: %s", err)
+		}
+	}
+
+	return nil
+}
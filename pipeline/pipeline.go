@@ -0,0 +1,150 @@
+// Package pipeline runs the stages of fbbotscan: the scheduler, the
+// fetchers, the storer and the classifier. Each can run in a process
+// of its own, talking to the others through RabbitMQ, or all of them
+// in one process with an in-memory broker.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/health"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/shutdown"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Stages in the order they are started
+var Stages = []string{
+	"scheduler",
+	"posts-fetch",
+	"comments-fetch",
+	"comments-store",
+	"comments-classify",
+}
+
+// What the stages running in one process share
+type Env struct {
+	Config *config.Config
+	Broker pubsub.Broker
+	Store  db.Store
+
+	fb *fbbot.FBApp
+}
+
+// Connect to the database of cfg
+func NewEnv(cfg *config.Config, broker pubsub.Broker) (*Env, error) {
+	store, err := db.NewStore(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Connect(); err != nil {
+		return nil, err
+	}
+	health.Ready("db", func(ctx context.Context) error {
+		return store.Ping()
+	})
+
+	return &Env{
+		Config: cfg,
+		Broker: broker,
+		Store:  store,
+	}, nil
+}
+
+// Run the given stages until stop is cancelled, then shut them all
+//  down at once within amqp.shutdown_seconds
+func Run(stop context.Context, env *Env, stages ...string) error {
+	var scheduled chan error
+	consumers := make([]*pubsub.Consumer, 0, len(stages))
+	for _, stage := range stages {
+		var c *pubsub.Consumer
+		var err error
+		switch stage {
+		case "scheduler":
+			scheduled = make(chan error, 1)
+			go func() {
+				scheduled <- RunScheduler(stop, env)
+			}()
+			continue
+		case "posts-fetch", "comments-fetch":
+			c, err = StartFetcher(env, stage)
+		case "comments-store":
+			c, err = StartStorer(env)
+		case "comments-classify":
+			c, err = StartClassifier(env)
+		default:
+			err = fmt.Errorf("Unknown stage %s", stage)
+		}
+		if err != nil {
+			return err
+		}
+		consumers = append(consumers, c)
+	}
+	log.Infof("Running %s", strings.Join(stages, ", "))
+
+	// The scheduler only returns early on errors
+	var err error
+	select {
+	case err = <-scheduled:
+	case <-stop.Done():
+		if scheduled != nil {
+			err = <-scheduled
+		}
+	}
+
+	log.Printf("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown.Timeout(env.Config.AMQP.ShutdownSeconds))
+	defer cancel()
+	errs := make(chan error, len(consumers))
+	for _, c := range consumers {
+		go func(c *pubsub.Consumer) {
+			errs <- c.Shutdown(ctx)
+		}(c)
+	}
+	for range consumers {
+		if serr := <-errs; serr != nil {
+			log.Errorf("%s", serr)
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+
+	return err
+}
+
+// Graph API app, shared by the fetchers
+func (e *Env) fbApp() (*fbbot.FBApp, error) {
+	if e.fb != nil {
+		return e.fb, nil
+	}
+
+	app, err := fbbot.NewFromConfig(e.Config)
+	if err != nil {
+		return nil, err
+	}
+	// Validating the token is a Graph API call itself
+	health.Ready("graph", health.Cached(app.CheckToken, 5*time.Minute))
+
+	e.fb = app
+	return app, nil
+}
+
+// Consume opts.Queue with the workers, prefetch and retries from
+//  [consumers]
+func (e *Env) consume(opts pubsub.ConsumerOptions, handle pubsub.Handler) (*pubsub.Consumer, error) {
+	settings := e.Config.Consumer(opts.Queue)
+	log.Infof("Consuming %s with %d workers, prefetch %d", opts.Queue, settings.Workers, settings.Prefetch)
+
+	opts.Workers = settings.Workers
+	opts.Prefetch = settings.Prefetch
+	opts.Retries = settings.Retries
+	opts.RetryDelay = time.Duration(settings.RetrySeconds) * time.Second
+
+	return pubsub.NewConsumer(e.Broker, opts, handle)
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	fb "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/db"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/shutdown"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Publish the pages and objects due for a check to the fetch queues
+//  every few seconds until stop is cancelled
+func RunScheduler(stop context.Context, env *Env) error {
+	cfg := env.Config
+	publisher, err := env.Broker.Publisher("comments-fetch", "posts-fetch")
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Check pages for new posts
+		log.Infof("Loading page feeds that haven't been checked in %d seconds", cfg.FB.FeedCheckSeconds)
+		entries, err := env.Store.GetSchedulerPosts(cfg.FB.FeedCheckSeconds)
+		if err != nil {
+			return err
+		}
+
+		if err := schedule(stop, env.Store, publisher, "posts-fetch", entries); err != nil {
+			return err
+		}
+
+		// Check new objects for comments
+		log.Infof("Loading object comments that haven't been checked in %d seconds", cfg.FB.CommentsCheckSeconds)
+		entries, err = env.Store.GetSchedulerComments(cfg.FB.CommentsCheckSeconds, cfg.FB.FullCheckSeconds)
+		if err != nil {
+			return err
+		}
+
+		if err := schedule(stop, env.Store, publisher, "comments-fetch", entries); err != nil {
+			return err
+		}
+
+		// Sleep and re-do
+		log.Infof("Sleeping")
+		if !shutdown.Sleep(stop, 5*time.Second) {
+			break
+		}
+	}
+
+	return publisher.Close()
+}
+
+// Publish each entry to the given fetch queue and mark it as
+//  scheduled so it isn't picked up again until it was fetched.
+//  Stops between entries once ctx is cancelled. Each entry gets a
+//  new correlation ID and trace which follow it through the pipeline.
+func schedule(ctx context.Context, appdb db.Store, pub pubsub.Publisher, queueName string, entries []fb.QueueEntry) error {
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := scheduleEntry(ctx, appdb, pub, queueName, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scheduleEntry(ctx context.Context, appdb db.Store, pub pubsub.Publisher, queueName string, entry fb.QueueEntry) error {
+	ctx, span := tracing.Start(logging.WithCorrelationID(ctx, ""), "schedule "+queueName,
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("fb.object_id", entry.ObjectID)),
+	)
+	defer span.End()
+
+	logging.Entry(ctx).WithField("object_id", entry.ObjectID).Infof("Scheduling %s %s on %s (last checked: %s, full: %t)", entry.ObjectType, entry.ObjectID, queueName, entry.LastChecked, entry.Full)
+
+	if err := pub.PublishJSON(ctx, queueName, entry); err != nil {
+		return fmt.Errorf("Failed to publish: %s", err)
+	}
+
+	if err := appdb.WithContext(ctx).SetScheduled(entry.ObjectType, entry.ObjectID); err != nil {
+		return fmt.Errorf("Cannot set to scheduled: %s", err)
+	}
+	metrics.Scheduled.WithLabelValues(queueName).Inc()
+
+	return nil
+}
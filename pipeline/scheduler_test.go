@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	fb "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/db"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Records what is published instead of sending it anywhere
+type fakePublisher struct {
+	err       error
+	published []string
+}
+
+func (p *fakePublisher) PublishJSON(ctx context.Context, routingKey string, data interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, routingKey+":"+data.(fb.QueueEntry).ObjectID)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func TestSchedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		publishErr error
+		cancelled  bool
+		// Fetched this long ago after being scheduled, zero if not yet
+		checked       time.Duration
+		wantErr       bool
+		wantPublished []string
+		wantDue       []string
+	}{
+		{
+			name:          "publishes and marks scheduled",
+			wantPublished: []string{"posts-fetch:page1", "posts-fetch:page2"},
+			wantDue:       []string{},
+		},
+		{
+			name:          "not due again right after being fetched",
+			checked:       10 * time.Second,
+			wantPublished: []string{"posts-fetch:page1", "posts-fetch:page2"},
+			wantDue:       []string{},
+		},
+		{
+			name:          "due again once the last check is old enough",
+			checked:       2 * time.Minute,
+			wantPublished: []string{"posts-fetch:page1", "posts-fetch:page2"},
+			wantDue:       []string{"page1", "page2"},
+		},
+		{
+			name:       "publish fails",
+			publishErr: errors.New("broker down"),
+			wantErr:    true,
+			wantDue:    []string{"page1", "page2"},
+		},
+		{
+			name:      "cancelled",
+			cancelled: true,
+			wantDue:   []string{"page1", "page2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemory(&config.DBConfig{Pages: []string{"page1", "page2"}})
+			if err := store.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			pub := &fakePublisher{err: tt.publishErr}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			entries, err := store.GetSchedulerPosts(60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = schedule(ctx, store, pub, "posts-fetch", entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("schedule() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pub.published, tt.wantPublished) {
+				t.Errorf("published %v, want %v", pub.published, tt.wantPublished)
+			}
+
+			if tt.checked > 0 {
+				for _, entry := range entries {
+					if err := store.UpdateLastCheck(entry.ObjectType, entry.ObjectID, time.Now().Add(-tt.checked)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			due, err := store.GetSchedulerPosts(60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dueIDs := make([]string, 0, len(due))
+			for _, entry := range due {
+				dueIDs = append(dueIDs, entry.ObjectID)
+			}
+			if !reflect.DeepEqual(dueIDs, tt.wantDue) {
+				t.Errorf("due %v, want %v", dueIDs, tt.wantDue)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	fbbot "github.com/moensch/fbbotscan"
+	"github.com/moensch/fbbotscan/es"
+	"github.com/moensch/fbbotscan/health"
+	"github.com/moensch/fbbotscan/lang"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/pubsub"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"gopkg.in/olivere/elastic.v5"
+	"sync"
+	"time"
+)
+
+// Index fetched comments in ElasticSearch. Deliveries are acked
+//  once their bulk request went through, Shutdown waits for the
+//  final bulk flush.
+func StartStorer(env *Env) (*pubsub.Consumer, error) {
+	cfg := env.Config
+	settings := cfg.Consumer("comments-store")
+	// Deliveries stay unacked until their bulk request is committed
+	if settings.Prefetch < cfg.ES.BulkActions {
+		log.Warnf("Prefetch %d is below es.bulk_actions %d, bulk requests will only be sent every %d seconds", settings.Prefetch, cfg.ES.BulkActions, cfg.ES.BulkFlushSeconds)
+	}
+
+	// Setup ElasticSearch client
+	client := es.New(cfg.ES)
+	health.Ready("elasticsearch", client.Health)
+
+	ctx := context.Background()
+	if err := client.PutCommentTemplate(ctx); err != nil {
+		return nil, err
+	}
+
+	bulkWorkers := cfg.ES.BulkWorkers
+	if bulkWorkers < 1 {
+		bulkWorkers = 1
+	}
+
+	// Deliveries are acked once their bulk item went through
+	tracker := &bulkTracker{
+		pending:  make(map[elastic.BulkableRequest]pendingDelivery),
+		started:  make(map[int64]time.Time),
+		indexing: make(map[string]int),
+		waiting:  make(map[string][]func(index string, err error)),
+	}
+	processor, err := client.Client.BulkProcessor().
+		Name("fb-storer").
+		Workers(bulkWorkers).
+		BulkActions(cfg.ES.BulkActions).
+		BulkSize(cfg.ES.BulkSize).
+		FlushInterval(time.Duration(cfg.ES.BulkFlushSeconds) * time.Second).
+		Before(tracker.before).
+		After(tracker.after).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot start bulk processor: %s", err)
+	}
+
+	indices := client.CommentIndices()
+	return env.consume(pubsub.ConsumerOptions{
+		Queue: "comments-store",
+		Key:   commentKey,
+		Drained: func() {
+			// Flushes whatever is still queued
+			if err := processor.Close(); err != nil {
+				log.Errorf("Bulk processor close failed: %s", err)
+			}
+		},
+	}, func(ctx context.Context, d amqp.Delivery) error {
+		req, err := storeComment(ctx, d, client, indices, tracker)
+		if err != nil || req == nil {
+			return err
+		}
+		processor.Add(req)
+		return pubsub.ErrAckLater
+	})
+}
+
+// Edits and deletions of a comment are indexed in order
+func commentKey(d amqp.Delivery) string {
+	var entry fbbot.FBComment
+	json.Unmarshal(d.Body, &entry)
+	return entry.ID
+}
+
+// Build and track the bulk request indexing a comment or marking it
+//  deleted, none for deleted comments which were never indexed.
+//  Deletions of comments whose index request is still in flight are
+//  applied once it went through and return ErrAckLater.
+func storeComment(ctx context.Context, d amqp.Delivery, client *es.ES, indices *es.DailyIndices, tracker *bulkTracker) (elastic.BulkableRequest, error) {
+	entry := fbbot.FBComment{}
+	if err := pubsub.Decode(d, &entry); err != nil {
+		return nil, err
+	}
+	logger := logging.Entry(ctx).WithField("comment_id", entry.ID)
+
+	// Published before the fetcher detected languages
+	if entry.Language == lang.Unknown && !entry.Deleted {
+		entry.Language = lang.Detect(entry.Message)
+	}
+
+	// Comments go to the day-index matching their creation time
+	var index_name string
+	if !entry.Deleted {
+		created := entry.CreatedTime.Time
+		if created.IsZero() {
+			logger.Warnf("Comment %s has no created_time, using current time", entry.ID)
+			created = time.Now()
+		}
+
+		var err error
+		index_name, err = indices.For(ctx, created)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Edits and deletions go to the index holding the original
+	//  document rather than creating a second copy
+	if entry.Deleted || entry.Revision > 1 {
+		existing_index, err := findCommentIndex(ctx, client, entry.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot look up comment %s: %s", entry.ID, err)
+		}
+
+		if entry.Deleted && existing_index == "" {
+			settle := pubsub.Later(ctx)
+			waiting := tracker.whenIndexed(entry.ID, func(index string, err error) {
+				if err == nil {
+					err = updateDeleted(ctx, client, index, entry)
+				}
+				settle(err)
+			})
+			if waiting {
+				logger.Infof("Deleted comment %s is still being indexed, marking it deleted afterwards", entry.ID)
+				return nil, pubsub.ErrAckLater
+			}
+			logger.Warnf("Deleted comment %s was never indexed", entry.ID)
+			return nil, nil
+		}
+
+		if existing_index != "" {
+			index_name = existing_index
+		}
+	}
+
+	if entry.Deleted {
+		req := elastic.NewBulkUpdateRequest().
+			Index(index_name).
+			Type("fbcomment").
+			Id(entry.ID).
+			Doc(deletedFields(entry))
+		tracker.add(req, ctx, "")
+		return req, nil
+	}
+
+	req := elastic.NewBulkIndexRequest().
+		Index(index_name).
+		Type("fbcomment").
+		Id(entry.ID).
+		Doc(entry)
+	tracker.add(req, ctx, entry.ID)
+	return req, nil
+}
+
+// What a deletion changes of the indexed comment
+func deletedFields(entry fbbot.FBComment) map[string]interface{} {
+	return map[string]interface{}{
+		"deleted":      true,
+		"deleted_time": entry.DeletedTime,
+	}
+}
+
+// Mark a comment deleted right away rather than through the bulk
+//  processor, for deletions which waited for the comment's index request
+func updateDeleted(ctx context.Context, client *es.ES, index string, entry fbbot.FBComment) error {
+	started := time.Now()
+	_, err := client.Client.Update().
+		Index(index).
+		Type("fbcomment").
+		Id(entry.ID).
+		Doc(deletedFields(entry)).
+		Do(ctx)
+	tracing.Record(ctx, "index", started, err)
+	if err != nil {
+		metrics.Indexed.WithLabelValues("retried").Inc()
+		return fmt.Errorf("Cannot mark comment %s deleted: %s", entry.ID, err)
+	}
+	metrics.Indexed.WithLabelValues("stored").Inc()
+	return nil
+}
+
+// Maps bulk requests back to the AMQP deliveries (and the traces)
+//  they came from and times each commit. Deliveries are settled by
+//  their consumer, see pubsub.Later.
+type bulkTracker struct {
+	sync.Mutex
+	pending map[elastic.BulkableRequest]pendingDelivery
+	started map[int64]time.Time
+	// Index requests in flight by comment ID, and what waits for them
+	indexing map[string]int
+	waiting  map[string][]func(index string, err error)
+}
+
+type pendingDelivery struct {
+	settle  func(err error)
+	ctx     context.Context
+	comment string // ID of the comment indexed, if it is an index request
+}
+
+func (t *bulkTracker) add(req elastic.BulkableRequest, ctx context.Context, comment string) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending[req] = pendingDelivery{settle: pubsub.Later(ctx), ctx: ctx, comment: comment}
+	if comment != "" {
+		t.indexing[comment]++
+	}
+}
+
+// Call fn with the index of a comment once its index requests in
+//  flight went through, false if there are none
+func (t *bulkTracker) whenIndexed(comment string, fn func(index string, err error)) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.indexing[comment] == 0 {
+		return false
+	}
+	t.waiting[comment] = append(t.waiting[comment], fn)
+	return true
+}
+
+// An index request of a comment is done. Whatever waits for it runs
+//  on its own goroutine, it must not hold up the bulk processor.
+func (t *bulkTracker) indexed(comment string, index string, err error) {
+	t.Lock()
+	t.indexing[comment]--
+	var waiting []func(index string, err error)
+	if t.indexing[comment] <= 0 {
+		waiting = t.waiting[comment]
+		delete(t.indexing, comment)
+		delete(t.waiting, comment)
+	}
+	t.Unlock()
+
+	for _, fn := range waiting {
+		go fn(index, err)
+	}
+}
+
+func (t *bulkTracker) take(req elastic.BulkableRequest) (pendingDelivery, bool) {
+	t.Lock()
+	defer t.Unlock()
+	p, ok := t.pending[req]
+	delete(t.pending, req)
+	return p, ok
+}
+
+// Called by the bulk processor before each commit
+func (t *bulkTracker) before(executionId int64, requests []elastic.BulkableRequest) {
+	t.Lock()
+	defer t.Unlock()
+	t.started[executionId] = time.Now()
+}
+
+// Called by the bulk processor after each commit. Response items are
+//  in the same order as the requests.
+func (t *bulkTracker) after(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	t.Lock()
+	started, ok := t.started[executionId]
+	if ok {
+		metrics.IndexLatency.Observe(time.Since(started).Seconds())
+		delete(t.started, executionId)
+	}
+	t.Unlock()
+
+	if err != nil {
+		log.Errorf("Bulk request %d failed: %s", executionId, err)
+	}
+
+	acked := 0
+	for i, req := range requests {
+		p, ok := t.take(req)
+		if !ok {
+			log.Warnf("Bulk request %d: no delivery for %s", executionId, req.String())
+			continue
+		}
+		logger := logging.Entry(p.ctx)
+
+		if err != nil || response == nil || i >= len(response.Items) {
+			if err == nil {
+				err = fmt.Errorf("No response for %s", req.String())
+			}
+			tracing.Record(p.ctx, "index", started, err)
+			metrics.Indexed.WithLabelValues("retried").Inc()
+			p.settle(err)
+			if p.comment != "" {
+				t.indexed(p.comment, "", err)
+			}
+			continue
+		}
+
+		for op, item := range response.Items[i] {
+			var itemErr error
+			if item.Status < 200 || item.Status >= 300 {
+				itemErr = fmt.Errorf("Status %d: %s", item.Status, bulkError(item))
+			}
+			tracing.Record(p.ctx, "index", started, itemErr)
+
+			switch {
+			case item.Status >= 200 && item.Status < 300:
+				logger.Debugf("Bulk %s of comment %s to index %s succeeded", op, item.Id, item.Index)
+				metrics.Indexed.WithLabelValues("stored").Inc()
+				p.settle(nil)
+				acked++
+			case item.Status == 429 || item.Status >= 500:
+				// Overloaded or broken cluster, try again later
+				logger.Errorf("Bulk %s of comment %s failed with status %d: %s", op, item.Id, item.Status, bulkError(item))
+				metrics.Indexed.WithLabelValues("retried").Inc()
+				p.settle(itemErr)
+			default:
+				// Bad document, retrying won't help
+				logger.Errorf("Bulk %s of comment %s rejected with status %d: %s", op, item.Id, item.Status, bulkError(item))
+				metrics.Indexed.WithLabelValues("rejected").Inc()
+				p.settle(pubsub.Permanent(itemErr))
+			}
+			if p.comment != "" {
+				t.indexed(p.comment, item.Index, itemErr)
+			}
+		}
+	}
+
+	log.Infof("Bulk request %d: stored %d of %d comments", executionId, acked, len(requests))
+}
+
+func bulkError(item *elastic.BulkResponseItem) string {
+	if item.Error == nil {
+		return "unknown error"
+	}
+	return fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
+}
+
+// Find the index a comment was stored in, empty if it wasn't or its
+//  index was closed
+func findCommentIndex(ctx context.Context, client *es.ES, id string) (string, error) {
+	res, err := client.Client.Search().
+		Index(es.CommentSearchAlias).
+		Query(elastic.NewIdsQuery("fbcomment").Ids(id)).
+		IgnoreUnavailable(true).
+		Size(1).
+		Do(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(res.Hits.Hits) == 0 {
+		return "", nil
+	}
+	return res.Hits.Hits[0].Index, nil
+}
@@ -15,21 +15,37 @@ CREATE UNIQUE INDEX pages_page_id_idx ON pages(page_id);
 CREATE TABLE posts (
   "post_id" character varying(50) NOT NULL,
   "page_id" character varying(50) NOT NULL REFERENCES pages(page_id) ON DELETE CASCADE,
+  "created_time" timestamp with time zone NULL,
+  "message_length" integer NOT NULL default 0,
   "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone,
+  "updated" timestamp with time zone NULL,
   "last_check" timestamp with time zone NULL,
+  "last_full_check" timestamp with time zone NULL,
   "scheduled" boolean NOT NULL default 'f'
 );
 
 CREATE UNIQUE INDEX posts_post_id_idx ON posts(post_id);
 CREATE INDEX posts_page_id_idx ON posts(page_id);
+CREATE INDEX posts_created_time_idx ON posts(created_time);
 
 CREATE TABLE comments (
   "comment_id" character varying (50) NOT NULL,
   "post_id" character varying (50) NOT NULL REFERENCES posts(post_id) ON DELETE CASCADE,
   "parent_id" character varying (50) NULL,
   "user_id" character varying (50) NOT NULL,
+  "created_time" timestamp with time zone NULL,
+  "message_length" integer NOT NULL default 0,
+  "like_count" integer NOT NULL default 0,
+  "comment_count" integer NOT NULL default 0,
+  "message_hash" character varying (40) NULL,
+  "revision" integer NOT NULL default 0,
+  "published_revision" integer NOT NULL default 0,
+  "deleted" boolean NOT NULL default 'f',
+  "deleted_time" timestamp with time zone NULL,
   "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone,
+  "updated" timestamp with time zone NULL,
   "last_check" timestamp with time zone NULL,
+  "last_full_check" timestamp with time zone NULL,
   "scheduled" boolean NOT NULL default 'f'
 );
 
@@ -37,6 +53,74 @@ CREATE TABLE comments (
 CREATE UNIQUE INDEX comments_comment_id_idx ON comments(comment_id);
 CREATE INDEX comments_parent_id_idx ON comments(parent_id);
 CREATE INDEX comments_post_id_idx ON comments(post_id);
+CREATE INDEX comments_user_id_idx ON comments(user_id);
+CREATE INDEX comments_created_time_idx ON comments(created_time);
+CREATE INDEX comments_user_id_created_time_idx ON comments(user_id, created_time);
 ALTER TABLE comments ADD FOREIGN KEY (parent_id) REFERENCES comments(comment_id) ON DELETE CASCADE;
 
+CREATE TABLE comment_revisions (
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "revision" integer NOT NULL,
+  "message" text NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE UNIQUE INDEX comment_revisions_comment_id_revision_idx ON comment_revisions(comment_id, revision);
+
+CREATE TABLE verdicts (
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL,
+  "matched_id" character varying (50) NOT NULL,
+  "score" double precision NOT NULL,
+  "band" character varying (20) NOT NULL,
+  "method" character varying (20) NOT NULL default 'mlt',
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE INDEX verdicts_comment_id_idx ON verdicts(comment_id);
+CREATE INDEX verdicts_user_id_idx ON verdicts(user_id);
+
+CREATE TABLE campaigns (
+  "campaign_id" serial PRIMARY KEY,
+  "message" text NOT NULL,
+  "comment_count" integer NOT NULL default 0,
+  "user_count" integer NOT NULL default 0,
+  "page_count" integer NOT NULL default 0,
+  "first_seen" timestamp with time zone NULL,
+  "last_seen" timestamp with time zone NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone,
+  "updated" timestamp with time zone NULL
+);
+
+CREATE INDEX campaigns_last_seen_idx ON campaigns(last_seen);
+
+CREATE TABLE campaign_comments (
+  "campaign_id" integer NOT NULL REFERENCES campaigns(campaign_id) ON DELETE CASCADE,
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL,
+  "page_id" character varying (50) NOT NULL,
+  "created_time" timestamp with time zone NULL,
+  "similarity" double precision NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE UNIQUE INDEX campaign_comments_comment_id_idx ON campaign_comments(comment_id);
+CREATE INDEX campaign_comments_campaign_id_idx ON campaign_comments(campaign_id);
+
+CREATE TABLE networks (
+  "network_id" serial PRIMARY KEY,
+  "size" integer NOT NULL,
+  "density" double precision NOT NULL,
+  "weight" double precision NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE TABLE network_members (
+  "network_id" integer NOT NULL REFERENCES networks(network_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL
+);
+
+CREATE UNIQUE INDEX network_members_user_id_idx ON network_members(user_id);
+CREATE INDEX network_members_network_id_idx ON network_members(network_id);
+
 END;
@@ -0,0 +1,16 @@
+-- Classifier verdicts
+BEGIN;
+
+CREATE TABLE verdicts (
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL,
+  "matched_id" character varying (50) NOT NULL,
+  "score" double precision NOT NULL,
+  "band" character varying (20) NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE INDEX verdicts_comment_id_idx ON verdicts(comment_id);
+CREATE INDEX verdicts_user_id_idx ON verdicts(user_id);
+
+END;
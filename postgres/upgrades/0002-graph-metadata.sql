@@ -0,0 +1,19 @@
+-- Graph API timestamps and counts on posts and comments
+BEGIN;
+
+ALTER TABLE posts ADD COLUMN "created_time" timestamp with time zone NULL;
+ALTER TABLE posts ADD COLUMN "message_length" integer NOT NULL default 0;
+ALTER TABLE posts ADD COLUMN "updated" timestamp with time zone NULL;
+
+CREATE INDEX posts_created_time_idx ON posts(created_time);
+
+ALTER TABLE comments ADD COLUMN "created_time" timestamp with time zone NULL;
+ALTER TABLE comments ADD COLUMN "message_length" integer NOT NULL default 0;
+ALTER TABLE comments ADD COLUMN "like_count" integer NOT NULL default 0;
+ALTER TABLE comments ADD COLUMN "comment_count" integer NOT NULL default 0;
+ALTER TABLE comments ADD COLUMN "updated" timestamp with time zone NULL;
+
+CREATE INDEX comments_user_id_idx ON comments(user_id);
+CREATE INDEX comments_created_time_idx ON comments(created_time);
+
+END;
@@ -0,0 +1,21 @@
+-- Comment edit history and deletion tracking
+BEGIN;
+
+ALTER TABLE posts ADD COLUMN "last_full_check" timestamp with time zone NULL;
+
+ALTER TABLE comments ADD COLUMN "message_hash" character varying (40) NULL;
+ALTER TABLE comments ADD COLUMN "revision" integer NOT NULL default 0;
+ALTER TABLE comments ADD COLUMN "deleted" boolean NOT NULL default 'f';
+ALTER TABLE comments ADD COLUMN "deleted_time" timestamp with time zone NULL;
+ALTER TABLE comments ADD COLUMN "last_full_check" timestamp with time zone NULL;
+
+CREATE TABLE comment_revisions (
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "revision" integer NOT NULL,
+  "message" text NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE UNIQUE INDEX comment_revisions_comment_id_revision_idx ON comment_revisions(comment_id, revision);
+
+END;
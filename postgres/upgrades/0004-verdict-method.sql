@@ -0,0 +1,6 @@
+-- Verdicts record which similarity method found the match
+BEGIN;
+
+ALTER TABLE verdicts ADD COLUMN "method" character varying (20) NOT NULL default 'mlt';
+
+END;
@@ -0,0 +1,31 @@
+-- Near-duplicate comment campaigns
+BEGIN;
+
+CREATE TABLE campaigns (
+  "campaign_id" serial PRIMARY KEY,
+  "message" text NOT NULL,
+  "comment_count" integer NOT NULL default 0,
+  "user_count" integer NOT NULL default 0,
+  "page_count" integer NOT NULL default 0,
+  "first_seen" timestamp with time zone NULL,
+  "last_seen" timestamp with time zone NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone,
+  "updated" timestamp with time zone NULL
+);
+
+CREATE INDEX campaigns_last_seen_idx ON campaigns(last_seen);
+
+CREATE TABLE campaign_comments (
+  "campaign_id" integer NOT NULL REFERENCES campaigns(campaign_id) ON DELETE CASCADE,
+  "comment_id" character varying (50) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL,
+  "page_id" character varying (50) NOT NULL,
+  "created_time" timestamp with time zone NULL,
+  "similarity" double precision NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE UNIQUE INDEX campaign_comments_comment_id_idx ON campaign_comments(comment_id);
+CREATE INDEX campaign_comments_campaign_id_idx ON campaign_comments(campaign_id);
+
+END;
@@ -0,0 +1,6 @@
+-- Per-author comment timelines for the timing detector
+BEGIN;
+
+CREATE INDEX comments_user_id_created_time_idx ON comments(user_id, created_time);
+
+END;
@@ -0,0 +1,20 @@
+-- Coordinated author networks
+BEGIN;
+
+CREATE TABLE networks (
+  "network_id" serial PRIMARY KEY,
+  "size" integer NOT NULL,
+  "density" double precision NOT NULL,
+  "weight" double precision NOT NULL,
+  "created" timestamp with time zone DEFAULT ('now'::text)::timestamp(6) with time zone
+);
+
+CREATE TABLE network_members (
+  "network_id" integer NOT NULL REFERENCES networks(network_id) ON DELETE CASCADE,
+  "user_id" character varying (50) NOT NULL
+);
+
+CREATE UNIQUE INDEX network_members_user_id_idx ON network_members(user_id);
+CREATE INDEX network_members_network_id_idx ON network_members(network_id);
+
+END;
@@ -0,0 +1,7 @@
+-- Revision of each comment last published to the storer and classifier
+BEGIN;
+
+ALTER TABLE comments ADD COLUMN "published_revision" integer NOT NULL default 0;
+UPDATE comments SET published_revision = revision;
+
+END;
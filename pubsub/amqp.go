@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"fmt"
+	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/health"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"strings"
+)
+
+// RabbitMQ broker, every publisher and subscription has a connection
+//  of its own
+type AMQPBroker struct {
+	Config *config.AMQPConfig
+}
+
+func NewAMQPBroker(cfg *config.AMQPConfig) *AMQPBroker {
+	return &AMQPBroker{Config: cfg}
+}
+
+func (b *AMQPBroker) Publisher(queues ...string) (Publisher, error) {
+	pub := New(b.Config)
+	if err := pub.Connect(); err != nil {
+		return nil, err
+	}
+	if err := pub.SetupChannel(); err != nil {
+		return nil, fmt.Errorf("Failed to get AMQP channel: %s", err)
+	}
+	health.Live("amqp-publish "+strings.Join(queues, ","), ClosedCheck(pub.Conn, pub.Channel))
+
+	for _, queueName := range queues {
+		queue, err := pub.QueueDeclare(queueName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to declare queue: %s", err)
+		}
+		log.Infof("Declared AMQP queue: %s", queue.Name)
+	}
+
+	return pub, nil
+}
+
+func (b *AMQPBroker) Subscribe(queue string, tag string, prefetch int) (Subscription, error) {
+	pub := New(b.Config)
+	if err := pub.Connect(); err != nil {
+		return nil, err
+	}
+	if err := pub.SetupChannel(); err != nil {
+		return nil, fmt.Errorf("Failed to get AMQP channel: %s", err)
+	}
+	health.Live("amqp "+queue, ClosedCheck(pub.Conn, pub.Channel))
+
+	if err := pub.Channel.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("Channel Qos: %s", err)
+	}
+	if _, err := pub.QueueDeclare(queue); err != nil {
+		return nil, fmt.Errorf("Failed to declare queue: %s", err)
+	}
+
+	log.Infof("Starting Consume of %s (consumer tag %q)", queue, tag)
+	deliveries, err := pub.Channel.Consume(
+		queue, // name
+		tag,   // consumerTag,
+		false, // noAck
+		false, // exclusive
+		false, // noLocal
+		false, // noWait
+		nil,   // arguments
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Queue Consume: %s", err)
+	}
+
+	return &amqpSubscription{pubsub: pub, tag: tag, deliveries: deliveries}, nil
+}
+
+type amqpSubscription struct {
+	pubsub     *PubSub
+	tag        string
+	deliveries <-chan amqp.Delivery
+}
+
+func (s *amqpSubscription) Deliveries() <-chan amqp.Delivery {
+	return s.deliveries
+}
+
+func (s *amqpSubscription) Cancel() error {
+	if err := s.pubsub.Channel.Cancel(s.tag, true); err != nil {
+		return fmt.Errorf("Consumer cancel failed: %s", err)
+	}
+	return nil
+}
+
+// RabbitMQ redelivers whatever is unacked once the connection closes
+func (s *amqpSubscription) Close() error {
+	if err := s.pubsub.Conn.Close(); err != nil {
+		return fmt.Errorf("AMQP connection close error: %s", err)
+	}
+	return nil
+}
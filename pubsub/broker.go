@@ -0,0 +1,32 @@
+package pubsub
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+)
+
+// Broker moves messages between the pipeline stages: RabbitMQ
+//  (AMQPBroker) or, with every stage in one process, MemoryBroker
+type Broker interface {
+	// Publisher to the given queues
+	Publisher(queues ...string) (Publisher, error)
+	// Deliveries of a queue, at most prefetch of them unacked
+	Subscribe(queue string, tag string, prefetch int) (Subscription, error)
+}
+
+// Publishers are safe for concurrent use, the workers of a consumer
+//  share one
+type Publisher interface {
+	PublishJSON(ctx context.Context, routingKey string, data interface{}) error
+	Close() error
+}
+
+// Deliveries of one queue
+type Subscription interface {
+	Deliveries() <-chan amqp.Delivery
+	// Stop delivering, Deliveries is closed once the buffered ones
+	//  were handed out
+	Cancel() error
+	// Give up the subscription, unacked deliveries are requeued
+	Close() error
+}
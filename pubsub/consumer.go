@@ -0,0 +1,265 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/metrics"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+// Handle one delivery. Returning nil acks it, see Consumer for what
+//  happens to deliveries which fail.
+type Handler func(ctx context.Context, d amqp.Delivery) error
+
+// Returned by handlers which settle the delivery later on, like the
+//  storer once its bulk request went through. See Later.
+var ErrAckLater = errors.New("Delivery is acked later")
+
+type laterKey struct{}
+
+// For handlers returning ErrAckLater: the function to call with the
+//  outcome of the delivery once it is known. It is settled like one
+//  handled right away, except that failures aren't retried in process
+//  but requeued after RetryDelay.
+func Later(ctx context.Context) func(err error) {
+	if settle, ok := ctx.Value(laterKey{}).(func(err error)); ok {
+		return settle
+	}
+	return func(err error) {
+		logging.Entry(ctx).Errorf("Cannot settle delivery outside of a consumer: %v", err)
+	}
+}
+
+type permanentError struct {
+	error
+}
+
+// Mark a handler error as one retrying won't fix, e.g. a message
+//  which cannot be decoded. The delivery is dropped right away.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var perr permanentError
+	return errors.As(err, &perr)
+}
+
+// Decode the JSON body of a delivery into v. Failures are permanent.
+func Decode(d amqp.Delivery, v interface{}) error {
+	if err := json.Unmarshal(d.Body, v); err != nil {
+		return Permanent(fmt.Errorf("Cannot read message: %s", err))
+	}
+	return nil
+}
+
+type ConsumerOptions struct {
+	Queue    string
+	Workers  int
+	Prefetch int
+	// Deliveries with the same key go to the same worker, in order
+	Key KeyFunc
+	// How often a failed delivery is retried, RetryDelay and then
+	//  twice as long as before apart
+	Retries    int
+	RetryDelay time.Duration
+	// Called once every worker is done, before Shutdown requeues
+	//  what is still unacked
+	Drained func()
+}
+
+// Consumer runs a handler on the deliveries of a queue with a pool
+//  of workers until Shutdown. Deliveries are acked when the handler
+//  succeeds. Failed ones are retried, then requeued, and dropped when
+//  they fail again after being redelivered. Permanent errors drop
+//  them right away.
+type Consumer struct {
+	ConsumerOptions
+	handle   Handler
+	sub      Subscription
+	done     chan struct{}
+	stopping chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+}
+
+// Subscribe to a queue and handle its deliveries. The context
+//  handlers get is only cancelled once Shutdown stops waiting for
+//  them, so they can finish the deliveries in flight.
+func NewConsumer(broker Broker, opts ConsumerOptions, handle Handler) (*Consumer, error) {
+	sub, err := broker.Subscribe(opts.Queue, opts.Queue, opts.Prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Consumer{
+		ConsumerOptions: opts,
+		handle:          handle,
+		sub:             sub,
+		done:            make(chan struct{}),
+		stopping:        make(chan struct{}),
+		cancel:          cancel,
+	}
+
+	go func() {
+		Dispatch(sub.Deliveries(), c.Workers, c.Key, func(d amqp.Delivery) {
+			c.deliver(ctx, d)
+		})
+		log.Infof("handle: deliveries channel of %s closed", c.Queue)
+		if c.Drained != nil {
+			c.Drained()
+		}
+		close(c.done)
+	}()
+
+	return c, nil
+}
+
+func (c *Consumer) deliver(ctx context.Context, d amqp.Delivery) {
+	// Leave what is still buffered to the next consumer
+	if c.isStopping() {
+		c.settle(logging.Entry(ctx), d, "requeued", d.Nack(false, true))
+		return
+	}
+
+	ctx, span := ConsumeSpan(ctx, c.Queue, d)
+	ctx = context.WithValue(ctx, laterKey{}, func(err error) {
+		c.settleLater(ctx, d, err)
+	})
+	logger := logging.Entry(ctx)
+	logger.Debugf(
+		"got %d B delivery: [%v] %q",
+		len(d.Body),
+		d.DeliveryTag,
+		d.Body,
+	)
+	metrics.ObserveQueueLag(c.Queue, d.Timestamp)
+
+	err := c.handle(ctx, d)
+	delay := c.RetryDelay
+	for attempt := 0; attempt < c.Retries && c.retryable(err); attempt++ {
+		logger.Warnf("Handling delivery from %s failed, retrying in %s: %s", c.Queue, delay, err)
+		metrics.Deliveries.WithLabelValues(c.Queue, "retried").Inc()
+		select {
+		case <-time.After(delay):
+		case <-c.stopping:
+		}
+		if c.isStopping() {
+			break
+		}
+		delay *= 2
+		err = c.handle(ctx, d)
+	}
+	if errors.Is(err, ErrAckLater) {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+
+	if errors.Is(err, ErrAckLater) {
+		metrics.Deliveries.WithLabelValues(c.Queue, "deferred").Inc()
+		return
+	}
+	c.finish(logger, d, err)
+}
+
+// Settle a delivery whose handler returned ErrAckLater. Failures which
+//  would be requeued wait RetryDelay first so a broken backend doesn't
+//  get them back right away.
+func (c *Consumer) settleLater(ctx context.Context, d amqp.Delivery, err error) {
+	logger := logging.Entry(ctx)
+	if err == nil || isPermanent(err) || d.Redelivered {
+		c.finish(logger, d, err)
+		return
+	}
+
+	go func() {
+		select {
+		case <-time.After(c.RetryDelay):
+		case <-c.stopping:
+		}
+		c.finish(logger, d, err)
+	}()
+}
+
+// Ack, requeue or drop a handled delivery
+func (c *Consumer) finish(logger *log.Entry, d amqp.Delivery, err error) {
+	switch {
+	case err == nil:
+		c.settle(logger, d, "acked", d.Ack(false))
+	case isPermanent(err):
+		logger.Errorf("Dropping delivery from %s: %s", c.Queue, err)
+		c.settle(logger, d, "dropped", d.Nack(false, false))
+	case d.Redelivered && !c.isStopping():
+		logger.Errorf("Dropping delivery from %s, it failed again after being requeued: %s", c.Queue, err)
+		c.settle(logger, d, "dropped", d.Nack(false, false))
+	default:
+		logger.Errorf("Requeueing delivery from %s: %s", c.Queue, err)
+		c.settle(logger, d, "requeued", d.Nack(false, true))
+	}
+}
+
+func (c *Consumer) retryable(err error) bool {
+	return err != nil && !errors.Is(err, ErrAckLater) && !isPermanent(err) && !c.isStopping()
+}
+
+func (c *Consumer) isStopping() bool {
+	select {
+	case <-c.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Count the outcome of an ack or nack unless it failed
+func (c *Consumer) settle(logger *log.Entry, d amqp.Delivery, outcome string, err error) {
+	if err != nil {
+		logger.Errorf("Cannot settle delivery %d from %s: %s", d.DeliveryTag, c.Queue, err)
+		return
+	}
+	metrics.Deliveries.WithLabelValues(c.Queue, outcome).Inc()
+}
+
+// Stop consuming and wait until ctx is done for the handlers to
+//  finish. Whatever is unacked after that is requeued. Calls after
+//  the first one do nothing.
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	var err error
+	c.stopOnce.Do(func() {
+		err = c.shutdown(ctx)
+	})
+	return err
+}
+
+func (c *Consumer) shutdown(ctx context.Context) error {
+	defer c.cancel()
+	close(c.stopping)
+
+	// will close() the deliveries channel
+	if err := c.sub.Cancel(); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		err = fmt.Errorf("Deliveries of %s still in flight: %s", c.Queue, ctx.Err())
+	}
+
+	if err := c.sub.Close(); err != nil {
+		return err
+	}
+	log.Printf("Consumer of %s shut down", c.Queue)
+
+	return err
+}
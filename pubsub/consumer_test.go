@@ -0,0 +1,229 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"github.com/moensch/fbbotscan/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/streadway/amqp"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+// Handler failing the first failures calls with err and recording
+//  whether each delivery it got was redelivered
+type recorder struct {
+	mu          sync.Mutex
+	redelivered []bool
+	failures    int
+	err         error
+	// Settle through Later instead of returning the outcome
+	later bool
+}
+
+func (r *recorder) handle(ctx context.Context, d amqp.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redelivered = append(r.redelivered, d.Redelivered)
+
+	var err error
+	if r.failures < 0 || len(r.redelivered) <= r.failures {
+		err = r.err
+	}
+	if r.later {
+		go Later(ctx)(err)
+		return ErrAckLater
+	}
+	return err
+}
+
+func (r *recorder) calls() []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool{}, r.redelivered...)
+}
+
+func deliveries(queue string, outcome string) float64 {
+	var m dto.Metric
+	if err := metrics.Deliveries.WithLabelValues(queue, outcome).Write(&m); err != nil {
+		return -1
+	}
+	return m.GetCounter().GetValue()
+}
+
+// Wait until nothing is queued or unacked anymore and the outcome
+//  was counted
+func waitSettled(t *testing.T, q *memoryQueue, sub *memorySubscription, counted func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		q.Lock()
+		settled := len(q.messages) == 0 && len(sub.unacked) == 0
+		q.Unlock()
+		if settled && counted() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("delivery not settled in time")
+}
+
+func TestConsumer(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *recorder
+		// Whether each call got a redelivered message
+		wantCalls   []bool
+		wantOutcome string
+	}{
+		{
+			name:        "acked",
+			handler:     &recorder{},
+			wantCalls:   []bool{false},
+			wantOutcome: "acked",
+		},
+		{
+			name:        "permanent errors are dropped right away",
+			handler:     &recorder{failures: -1, err: Permanent(errFailed)},
+			wantCalls:   []bool{false},
+			wantOutcome: "dropped",
+		},
+		{
+			name:        "retried in process",
+			handler:     &recorder{failures: 1, err: errFailed},
+			wantCalls:   []bool{false, false},
+			wantOutcome: "acked",
+		},
+		{
+			name:        "requeued once retries are used up",
+			handler:     &recorder{failures: 2, err: errFailed},
+			wantCalls:   []bool{false, false, true},
+			wantOutcome: "acked",
+		},
+		{
+			name:        "dropped when failing again after being requeued",
+			handler:     &recorder{failures: -1, err: errFailed},
+			wantCalls:   []bool{false, false, true, true},
+			wantOutcome: "dropped",
+		},
+		{
+			name:        "acked later",
+			handler:     &recorder{later: true},
+			wantCalls:   []bool{false},
+			wantOutcome: "acked",
+		},
+		{
+			name:        "requeued later without retrying",
+			handler:     &recorder{later: true, failures: 1, err: errFailed},
+			wantCalls:   []bool{false, true},
+			wantOutcome: "acked",
+		},
+		{
+			name:        "dropped later when failing again",
+			handler:     &recorder{later: true, failures: -1, err: errFailed},
+			wantCalls:   []bool{false, true},
+			wantOutcome: "dropped",
+		},
+		{
+			name:        "permanent errors later are dropped right away",
+			handler:     &recorder{later: true, failures: -1, err: Permanent(errFailed)},
+			wantCalls:   []bool{false},
+			wantOutcome: "dropped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := "test-" + tt.name
+			before := deliveries(queue, tt.wantOutcome)
+			broker := NewMemoryBroker()
+			pub, _ := broker.Publisher(queue)
+			if err := pub.PublishJSON(context.Background(), queue, "message"); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := NewConsumer(broker, ConsumerOptions{
+				Queue:      queue,
+				Workers:    1,
+				Retries:    1,
+				RetryDelay: time.Millisecond,
+			}, tt.handler.handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Shutdown(context.Background())
+
+			waitSettled(t, broker.queue(queue), c.sub.(*memorySubscription), func() bool {
+				return deliveries(queue, tt.wantOutcome) > before
+			})
+			if got := tt.handler.calls(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("handler calls redelivered %v, want %v", got, tt.wantCalls)
+			}
+			if n := deliveries(queue, tt.wantOutcome) - before; n != 1 {
+				t.Errorf("%d deliveries %s, want 1", int(n), tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestConsumerShutdown(t *testing.T) {
+	const queue = "test-shutdown"
+	broker := NewMemoryBroker()
+	pub, _ := broker.Publisher(queue)
+	if err := pub.PublishJSON(context.Background(), queue, "message"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	drained := make(chan struct{})
+	c, err := NewConsumer(broker, ConsumerOptions{
+		Queue:   queue,
+		Workers: 1,
+		Drained: func() { close(drained) },
+	}, func(ctx context.Context, d amqp.Delivery) error {
+		close(started)
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err == nil {
+		t.Error("Shutdown() did not report the delivery in flight")
+	}
+
+	q := broker.queue(queue)
+	q.Lock()
+	requeued := len(q.messages)
+	q.Unlock()
+	if requeued != 1 {
+		t.Errorf("%d messages requeued, want the one in flight", requeued)
+	}
+
+	close(release)
+	<-drained
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown() = %s, want nothing to do", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var v struct {
+		ID string `json:"id"`
+	}
+	if err := Decode(amqp.Delivery{Body: []byte(`{"id": "1"}`)}, &v); err != nil || v.ID != "1" {
+		t.Errorf("Decode() = %v, %+v", err, v)
+	}
+	if err := Decode(amqp.Delivery{Body: []byte(`{"id": `)}, &v); !isPermanent(err) {
+		t.Errorf("Decode() of invalid JSON = %v, want a permanent error", err)
+	}
+}
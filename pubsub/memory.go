@@ -0,0 +1,207 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/moensch/fbbotscan/tracing"
+	"github.com/streadway/amqp"
+	"sync"
+)
+
+// Broker within one process, for running every stage together
+//  without RabbitMQ. Queued and unacked messages are lost when the
+//  process exits.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues: make(map[string]*memoryQueue),
+	}
+}
+
+type memoryQueue struct {
+	sync.Mutex
+	// Signalled when messages are added, acked or a subscription
+	//  is cancelled
+	changed  *sync.Cond
+	messages []amqp.Delivery
+	nextTag  uint64
+}
+
+func (b *MemoryBroker) queue(name string) *memoryQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{}
+		q.changed = sync.NewCond(q)
+		b.queues[name] = q
+	}
+	return q
+}
+
+func (b *MemoryBroker) Publisher(queues ...string) (Publisher, error) {
+	return &memoryPublisher{broker: b}, nil
+}
+
+func (b *MemoryBroker) Subscribe(queue string, tag string, prefetch int) (Subscription, error) {
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	s := &memorySubscription{
+		queue:      b.queue(queue),
+		prefetch:   prefetch,
+		deliveries: make(chan amqp.Delivery),
+		stop:       make(chan struct{}),
+		unacked:    make(map[uint64]amqp.Delivery),
+	}
+	go s.deliver()
+	return s, nil
+}
+
+type memoryPublisher struct {
+	broker *MemoryBroker
+}
+
+func (p *memoryPublisher) PublishJSON(ctx context.Context, routingKey string, data interface{}) error {
+	jsonblob, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Failed to create JSON message: %s", err)
+	}
+
+	msg, span := newPublishing(ctx, routingKey, "application/json", jsonblob)
+	defer tracing.End(span, nil)
+
+	q := p.broker.queue(routingKey)
+	q.Lock()
+	defer q.Unlock()
+	q.messages = append(q.messages, amqp.Delivery{
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  msg.DeliveryMode,
+		CorrelationId: msg.CorrelationId,
+		Timestamp:     msg.Timestamp,
+		RoutingKey:    routingKey,
+		Body:          msg.Body,
+	})
+	q.changed.Broadcast()
+
+	return nil
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
+
+type memorySubscription struct {
+	queue      *memoryQueue
+	prefetch   int
+	deliveries chan amqp.Delivery
+	stop       chan struct{}
+	// Guarded by the queue lock
+	cancelled bool
+	unacked   map[uint64]amqp.Delivery
+}
+
+func (s *memorySubscription) Deliveries() <-chan amqp.Delivery {
+	return s.deliveries
+}
+
+// Hand out messages until cancelled, at most prefetch unacked
+func (s *memorySubscription) deliver() {
+	defer close(s.deliveries)
+
+	q := s.queue
+	for {
+		q.Lock()
+		for !s.cancelled && (len(q.messages) == 0 || len(s.unacked) >= s.prefetch) {
+			q.changed.Wait()
+		}
+		if s.cancelled {
+			q.Unlock()
+			return
+		}
+		d := q.messages[0]
+		q.messages = q.messages[1:]
+		q.nextTag++
+		d.DeliveryTag = q.nextTag
+		d.Acknowledger = s
+		s.unacked[d.DeliveryTag] = d
+		q.Unlock()
+
+		select {
+		case s.deliveries <- d:
+		case <-s.stop:
+			s.Nack(d.DeliveryTag, false, true)
+			return
+		}
+	}
+}
+
+func (s *memorySubscription) Cancel() error {
+	s.queue.Lock()
+	defer s.queue.Unlock()
+	if !s.cancelled {
+		s.cancelled = true
+		close(s.stop)
+		s.queue.changed.Broadcast()
+	}
+	return nil
+}
+
+// Like closing an AMQP connection, requeues what is still unacked
+func (s *memorySubscription) Close() error {
+	s.Cancel()
+
+	s.queue.Lock()
+	defer s.queue.Unlock()
+	for tag, d := range s.unacked {
+		s.queue.messages = append(s.queue.messages, d)
+		delete(s.unacked, tag)
+	}
+	s.queue.changed.Broadcast()
+	return nil
+}
+
+func (s *memorySubscription) Ack(tag uint64, multiple bool) error {
+	return s.settle(tag, multiple, false)
+}
+
+func (s *memorySubscription) Nack(tag uint64, multiple bool, requeue bool) error {
+	return s.settle(tag, multiple, requeue)
+}
+
+func (s *memorySubscription) Reject(tag uint64, requeue bool) error {
+	return s.settle(tag, false, requeue)
+}
+
+// Forget an unacked delivery (with multiple, all up to tag) and
+//  requeue it if asked to
+func (s *memorySubscription) settle(tag uint64, multiple bool, requeue bool) error {
+	s.queue.Lock()
+	defer s.queue.Unlock()
+
+	settled := 0
+	for t, d := range s.unacked {
+		if t != tag && !(multiple && t < tag) {
+			continue
+		}
+		delete(s.unacked, t)
+		settled++
+		if requeue {
+			d.Acknowledger = nil
+			d.Redelivered = true
+			s.queue.messages = append(s.queue.messages, d)
+		}
+	}
+	if settled == 0 {
+		return fmt.Errorf("Unknown delivery tag %d", tag)
+	}
+	s.queue.changed.Broadcast()
+
+	return nil
+}
@@ -1,17 +1,28 @@
 package pubsub
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
 	"github.com/moensch/fbbotscan/config"
+	"github.com/moensch/fbbotscan/health"
+	"github.com/moensch/fbbotscan/logging"
+	"github.com/moensch/fbbotscan/tracing"
+	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
 )
 
 type PubSub struct {
 	Config  *config.AMQPConfig
 	Conn    *amqp.Connection
 	Channel *amqp.Channel
+
+	// Keeps the frames of concurrent publishes apart
+	publishing sync.Mutex
 }
 
 func New(cfg *config.AMQPConfig) *PubSub {
@@ -27,12 +38,12 @@ func New(cfg *config.AMQPConfig) *PubSub {
 }
 
 func (p *PubSub) Initialize() error {
-	log.Debugf("AMQP Connection: %s", p.Config.URI)
+	log.Debugf("AMQP Connection: %s", p.Config.RedactedURI())
 	return nil
 }
 
 func (p *PubSub) Connect() error {
-	log.Debugf("Connecting to AMQP: %s", p.Config.URI)
+	log.Debugf("Connecting to AMQP: %s", p.Config.RedactedURI())
 	var err error
 	p.Conn, err = amqp.Dial(p.Config.URI)
 	if err != nil {
@@ -52,6 +63,21 @@ func (p *PubSub) SetupChannel() error {
 	return err
 }
 
+// Close the channel and connection, waiting for pending publishes
+func (p *PubSub) Close() error {
+	if p.Channel != nil {
+		if err := p.Channel.Close(); err != nil {
+			return fmt.Errorf("Cannot close AMQP channel: %s", err)
+		}
+	}
+	if p.Conn != nil {
+		if err := p.Conn.Close(); err != nil {
+			return fmt.Errorf("Cannot close AMQP connection: %s", err)
+		}
+	}
+	return nil
+}
+
 // Setup a persistent queue
 func (p *PubSub) QueueDeclare(name string) (amqp.Queue, error) {
 	return p.Channel.QueueDeclare(
@@ -66,33 +92,126 @@ func (p *PubSub) QueueDeclare(name string) (amqp.Queue, error) {
 
 // Shortcut to publishing arbitrary interfaces
 //  as JSON
-func (p *PubSub) PublishJSON(routingKey string, data interface{}) error {
+func (p *PubSub) PublishJSON(ctx context.Context, routingKey string, data interface{}) error {
 	jsonblob, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("Failed to create JSON message: %s", err)
 	}
 
-	return p.Publish(routingKey, "application/json", jsonblob)
+	return p.Publish(ctx, routingKey, "application/json", jsonblob)
 }
 
-// Publish a byte string using a given routing key (in our case, always the queue name)
-func (p *PubSub) Publish(routingKey string, contentType string, body []byte) error {
-	log.Debugf("Sending message to %s: %s", routingKey, string(body))
+// Publish a byte string using a given routing key (in our case, always the queue name).
+//  Safe for concurrent use. Consumers tell how long it was queued from the timestamp and pick
+//  up the correlation ID of ctx with DeliveryContext.
+func (p *PubSub) Publish(ctx context.Context, routingKey string, contentType string, body []byte) error {
+	msg, span := newPublishing(ctx, routingKey, contentType, body)
 
+	p.publishing.Lock()
+	defer p.publishing.Unlock()
 	err := p.Channel.Publish(
 		"",         // default exchange
 		routingKey, // routing key (queue name)
 		true,       // mandatory
 		false,      // immediate
-		amqp.Publishing{
-			Headers:         amqp.Table{},
-			ContentType:     contentType,
-			ContentEncoding: "",
-			Body:            body,
-			DeliveryMode:    amqp.Persistent,
-			Priority:        0,
-		},
+		msg,
 	)
+	tracing.End(span, err)
 
 	return err
 }
+
+// Message with the correlation ID and trace context of ctx, and the
+//  span of publishing it which the caller has to end
+func newPublishing(ctx context.Context, routingKey string, contentType string, body []byte) (amqp.Publishing, trace.Span) {
+	logging.Entry(ctx).Debugf("Sending message to %s: %s", routingKey, string(body))
+
+	ctx, span := tracing.Start(ctx, "publish "+routingKey,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(routingKey)...),
+	)
+	headers := amqp.Table{}
+	tracing.Inject(ctx, headerCarrier(headers))
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     contentType,
+		ContentEncoding: "",
+		Body:            body,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        0,
+		Timestamp:       time.Now(),
+		CorrelationId:   logging.CorrelationID(ctx),
+	}, span
+}
+
+// Context carrying the correlation ID and trace of a delivery.
+//  Messages published without a correlation ID get a new one.
+func DeliveryContext(ctx context.Context, d amqp.Delivery) context.Context {
+	ctx = tracing.Extract(ctx, headerCarrier(d.Headers))
+	return logging.WithCorrelationID(ctx, d.CorrelationId)
+}
+
+// Context of a delivery with a span for handling it, which the
+//  caller has to end
+func ConsumeSpan(ctx context.Context, queue string, d amqp.Delivery) (context.Context, trace.Span) {
+	return tracing.Start(DeliveryContext(ctx, d), "consume "+queue,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(queue)...),
+	)
+}
+
+func messagingAttributes(queue string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", queue),
+	}
+}
+
+// Carries trace context in AMQP message headers
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Health check which fails once the connection or channel closed.
+//  Nothing reconnects, so the process has to be restarted.
+func ClosedCheck(conn *amqp.Connection, channel *amqp.Channel) health.Check {
+	var mu sync.Mutex
+	var closed error
+
+	watch := func(what string, notify chan *amqp.Error) {
+		go func() {
+			err := <-notify
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				closed = fmt.Errorf("AMQP %s closed: %s", what, err)
+			} else {
+				closed = fmt.Errorf("AMQP %s closed", what)
+			}
+		}()
+	}
+	watch("connection", conn.NotifyClose(make(chan *amqp.Error, 1)))
+	watch("channel", channel.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return closed
+	}
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"github.com/streadway/amqp"
+	"hash/fnv"
+	"sync"
+)
+
+// Pick the key of a delivery. Deliveries with the same key are
+//  handled in the order they arrived.
+type KeyFunc func(d amqp.Delivery) string
+
+// Handle deliveries with a pool of workers until the deliveries
+//  channel is closed and every worker is done. Without a key
+//  function any idle worker takes the next delivery, otherwise each
+//  key always goes to the same worker.
+func Dispatch(deliveries <-chan amqp.Delivery, workers int, key KeyFunc, handle func(d amqp.Delivery)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queues := make([]chan amqp.Delivery, workers)
+	for i := range queues {
+		if key == nil && i > 0 {
+			// Shared by all workers
+			queues[i] = queues[0]
+			continue
+		}
+		queues[i] = make(chan amqp.Delivery)
+	}
+
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		wg.Add(1)
+		go func(queue chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range queue {
+				handle(d)
+			}
+		}(queue)
+	}
+
+	for d := range deliveries {
+		i := 0
+		if key != nil {
+			h := fnv.New32a()
+			h.Write([]byte(key(d)))
+			i = int(h.Sum32() % uint32(workers))
+		}
+		queues[i] <- d
+	}
+
+	if key == nil {
+		close(queues[0])
+	} else {
+		for _, queue := range queues {
+			close(queue)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,88 @@
+// Package secrets keeps passwords and tokens out of the logs.
+package secrets
+
+import (
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// Replacement for secret values
+const Redacted = "REDACTED"
+
+// Values shorter than this are too likely to show up in log output
+//  on their own to be replaced everywhere
+const minLength = 8
+
+// Default passwords which are also ordinary words, e.g. the postgres
+//  user. Replacing them would garble unrelated messages.
+var common = map[string]bool{
+	"postgres": true,
+	"password": true,
+	"rabbitmq": true,
+	"changeme": true,
+	"elastic":  true,
+	"secret":   true,
+	"guest":    true,
+	"admin":    true,
+}
+
+var (
+	mu     sync.RWMutex
+	values []string
+	hooked sync.Once
+)
+
+// Register values which must never show up in log output. The first
+//  call adds a hook to the standard logger which replaces them in
+//  every message and field. Short and common values are ignored, log
+//  sites must redact those themselves (see config.Redacted).
+func Register(secrets ...string) {
+	hooked.Do(func() {
+		log.AddHook(hook{})
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range secrets {
+		if len(s) >= minLength && !common[strings.ToLower(s)] {
+			values = append(values, s)
+		}
+	}
+}
+
+// Replace all registered secrets in s
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, v := range values {
+		s = strings.Replace(s, v, Redacted, -1)
+	}
+	return s
+}
+
+type hook struct{}
+
+func (h hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h hook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+
+	// Data may be shared with other entries, don't modify it in place
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			data[k] = Redact(value)
+		case error:
+			data[k] = Redact(value.Error())
+		default:
+			data[k] = v
+		}
+	}
+	entry.Data = data
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+// Registered secrets are global, forget them after each test
+func reset() {
+	mu.Lock()
+	values = nil
+	mu.Unlock()
+}
+
+func TestRedact(t *testing.T) {
+	defer reset()
+	Register("hunter2hunter2", "short", "Postgres", "rabbitmq", "", "app-secret-value")
+
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"secret", "connecting with hunter2hunter2", "connecting with REDACTED"},
+		{"every occurrence", "hunter2hunter2/app-secret-value/hunter2hunter2", "REDACTED/REDACTED/REDACTED"},
+		{"too short", "a short message", "a short message"},
+		{"common value in any case", "user Postgres on rabbitmq", "user Postgres on rabbitmq"},
+		{"nothing to redact", "fetched 25 comments", "fetched 25 comments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.s); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHook(t *testing.T) {
+	defer reset()
+	Register("hunter2hunter2")
+
+	var out bytes.Buffer
+	logger := log.New()
+	logger.Out = &out
+	logger.Formatter = &log.TextFormatter{DisableTimestamp: true}
+	logger.AddHook(hook{})
+
+	fields := log.Fields{
+		"connstr": "password=hunter2hunter2",
+		"error":   errors.New("login with hunter2hunter2 failed"),
+		"count":   3,
+	}
+	logger.WithFields(fields).Info("using hunter2hunter2")
+
+	if strings.Contains(out.String(), "hunter2hunter2") {
+		t.Errorf("secret logged: %s", out.String())
+	}
+	for _, want := range []string{`msg="using REDACTED"`, `connstr="password=REDACTED"`, `error="login with REDACTED failed"`, "count=3"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%s not logged: %s", want, out.String())
+		}
+	}
+
+	if fields["connstr"] != "password=hunter2hunter2" {
+		t.Error("hook modified the fields in place")
+	}
+}
@@ -0,0 +1,49 @@
+// Package shutdown lets services stop cleanly on SIGINT and SIGTERM.
+package shutdown
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How long consumers get to finish in-flight deliveries unless
+//  shutdown_seconds says otherwise
+const DefaultTimeout = 30 * time.Second
+
+// Context which is cancelled on the first SIGINT or SIGTERM. A second
+//  signal exits right away.
+func OnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Warnf("Received %s, shutting down", <-signals)
+		cancel()
+		log.Fatalf("Received %s again, exiting", <-signals)
+	}()
+
+	return ctx
+}
+
+// Drain timeout from a shutdown_seconds setting
+func Timeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Sleep for d, false if ctx was cancelled before
+func Sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
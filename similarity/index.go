@@ -0,0 +1,149 @@
+package similarity
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// A document similar to a query
+type Match struct {
+	ID         string
+	Similarity float64
+}
+
+type bySimilarity []Match
+
+func (m bySimilarity) Len() int           { return len(m) }
+func (m bySimilarity) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m bySimilarity) Less(i, j int) bool { return m[i].Similarity > m[j].Similarity }
+
+// In-memory LSH index over MinHash signatures. Holds up to maxDocs
+//  documents, dropping the oldest ones first.
+type Index struct {
+	mu      sync.Mutex
+	maxDocs int
+	docs    map[string]Signature
+	order   []string
+	buckets [Bands]map[uint64][]string
+}
+
+func NewIndex(maxDocs int) *Index {
+	idx := &Index{
+		maxDocs: maxDocs,
+		docs:    make(map[string]Signature),
+		order:   make([]string, 0),
+	}
+	for b := range idx.buckets {
+		idx.buckets[b] = make(map[uint64][]string)
+	}
+	return idx
+}
+
+// Number of documents in the index
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.docs)
+}
+
+// Add a document. Texts without words are ignored, known IDs
+//  are replaced.
+func (idx *Index) Add(id string, text string) {
+	sig, ok := Sign(text)
+	if !ok {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.docs[id]; ok {
+		idx.remove(id)
+	}
+
+	idx.docs[id] = sig
+	idx.order = append(idx.order, id)
+	for b := range idx.buckets {
+		key := bandKey(sig, b)
+		idx.buckets[b][key] = append(idx.buckets[b][key], id)
+	}
+
+	for idx.maxDocs > 0 && len(idx.docs) > idx.maxDocs {
+		idx.remove(idx.order[0])
+	}
+}
+
+// Documents at least threshold similar to text, most similar first
+func (idx *Index) Query(text string, threshold float64) []Match {
+	matches := make([]Match, 0)
+	sig, ok := Sign(text)
+	if !ok {
+		return matches
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for b := range idx.buckets {
+		for _, id := range idx.buckets[b][bandKey(sig, b)] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			if similarity := sig.Similarity(idx.docs[id]); similarity >= threshold {
+				matches = append(matches, Match{ID: id, Similarity: similarity})
+			}
+		}
+	}
+
+	sort.Sort(bySimilarity(matches))
+	return matches
+}
+
+// Caller must hold the lock
+func (idx *Index) remove(id string) {
+	sig := idx.docs[id]
+	delete(idx.docs, id)
+
+	if idx.order[0] == id {
+		idx.order = idx.order[1:]
+	} else {
+		for i, other := range idx.order {
+			if other == id {
+				idx.order = append(idx.order[:i], idx.order[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for b := range idx.buckets {
+		key := bandKey(sig, b)
+		bucket := idx.buckets[b][key]
+		for i, other := range bucket {
+			if other == id {
+				bucket = append(bucket[:i], bucket[i+1:]...)
+				break
+			}
+		}
+		if len(bucket) == 0 {
+			delete(idx.buckets[b], key)
+		} else {
+			idx.buckets[b][key] = bucket
+		}
+	}
+}
+
+// Hash of the signature rows making up band b
+func bandKey(sig Signature, b int) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, v := range sig[b*Rows : (b+1)*Rows] {
+		binary.LittleEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
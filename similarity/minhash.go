@@ -0,0 +1,123 @@
+// Package similarity finds near-duplicate texts locally using word
+// shingles and MinHash signatures, bucketed with locality sensitive
+// hashing. Similarities are estimated Jaccard coefficients of the
+// shingle sets, so 0.8 means the same thing regardless of how many
+// documents were seen before.
+package similarity
+
+import (
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Words per shingle
+	ShingleSize = 3
+	// Hash functions per signature, must equal Bands * Rows
+	SignatureSize = 128
+	// LSH banding. Pairs with a similarity of about 0.38 have an even
+	//  chance of becoming candidates (a bit below the usual estimate
+	//  (1/Bands)^(1/Rows), roughly 0.42), pairs from 0.5 on more than 0.85.
+	Bands = 32
+	Rows  = 4
+)
+
+// A MinHash signature
+type Signature [SignatureSize]uint64
+
+// Fixed, so signatures stay comparable across restarts
+var seeds = func() [SignatureSize]uint64 {
+	var s [SignatureSize]uint64
+	state := uint64(0x66627363616e)
+	for i := range s {
+		state += 0x9e3779b97f4a7c15
+		s[i] = mix(state)
+	}
+	return s
+}()
+
+// splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Lowercased words with punctuation stripped
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Hashed word shingles of a text. Texts shorter than one shingle
+//  are a single shingle of all their words.
+func Shingles(text string) map[uint64]bool {
+	w := words(text)
+	shingles := make(map[uint64]bool)
+	if len(w) == 0 {
+		return shingles
+	}
+
+	size := ShingleSize
+	if len(w) < size {
+		size = len(w)
+	}
+	for i := 0; i+size <= len(w); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(w[i:i+size], " ")))
+		shingles[h.Sum64()] = true
+	}
+
+	return shingles
+}
+
+// MinHash signature of a text, false if it has no words
+func Sign(text string) (Signature, bool) {
+	var sig Signature
+	shingles := Shingles(text)
+	if len(shingles) == 0 {
+		return sig, false
+	}
+
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for shingle := range shingles {
+		for i, seed := range seeds {
+			if h := mix(shingle ^ seed); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+
+	return sig, true
+}
+
+// Estimated Jaccard similarity of the texts behind two signatures
+func (s Signature) Similarity(other Signature) float64 {
+	same := 0
+	for i := range s {
+		if s[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / SignatureSize
+}
+
+// Exact Jaccard similarity of the shingle sets of two texts
+func Jaccard(a string, b string) float64 {
+	sa, sb := Shingles(a), Shingles(b)
+	if len(sa) == 0 || len(sb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for shingle := range sa {
+		if sb[shingle] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(sa)+len(sb)-shared)
+}
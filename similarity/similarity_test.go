@@ -0,0 +1,176 @@
+package similarity
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+const (
+	original = "Vote for the only candidate who will fix the roads and lower your taxes this year"
+	// Same message with one word changed
+	edited = "Vote for the only candidate who will fix the bridges and lower your taxes this year"
+	other  = "The weather in Hamburg was lovely today, we went for a long walk along the river"
+)
+
+// Chance that two documents with Jaccard similarity s share a band
+func candidateChance(s float64) float64 {
+	return 1 - math.Pow(1-math.Pow(s, Rows), Bands)
+}
+
+func TestBanding(t *testing.T) {
+	if Bands*Rows != SignatureSize {
+		t.Fatalf("Bands * Rows = %d, want SignatureSize %d", Bands*Rows, SignatureSize)
+	}
+
+	tests := []struct {
+		name       string
+		similarity float64
+		min, max   float64
+	}{
+		{"even chance", 0.38, 0.45, 0.55},
+		{"usual threshold estimate", math.Pow(1.0/Bands, 1.0/Rows), 0.6, 0.67},
+		{"default discard_similarity", 0.5, 0.85, 0.9},
+		{"default match_similarity", 0.8, 0.999, 1},
+		{"unrelated", 0.1, 0, 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p := candidateChance(tt.similarity); p < tt.min || p > tt.max {
+				t.Errorf("candidate chance at %1.3f = %1.3f, want %1.3f to %1.3f", tt.similarity, p, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"punctuation only", "?!...", 0},
+		{"shorter than a shingle", "hello world", 1},
+		{"one shingle", "one two three", 1},
+		{"overlapping shingles", "one two three four five", 3},
+		{"repeated shingles", "la la la la la", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(Shingles(tt.text)); got != tt.want {
+				t.Errorf("Shingles(%q) has %d shingles, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShinglesIgnoreCaseAndPunctuation(t *testing.T) {
+	a := Shingles("Hello, World! How are you?")
+	b := Shingles("hello world how are you")
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("shingles differ: %v vs %v", a, b)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		jaccard float64
+	}{
+		{"identical", original, original, 1},
+		// 14 shingles each, 3 of them changed
+		{"one word changed", original, edited, 11.0 / 17},
+		{"unrelated", original, other, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jaccard := Jaccard(tt.a, tt.b)
+			if math.Abs(jaccard-tt.jaccard) > 0.01 {
+				t.Errorf("Jaccard = %1.3f, want %1.3f", jaccard, tt.jaccard)
+			}
+
+			sa, _ := Sign(tt.a)
+			sb, _ := Sign(tt.b)
+			// The estimate's standard error is below 0.05 with 128 hashes
+			if estimate := sa.Similarity(sb); math.Abs(estimate-jaccard) > 0.15 {
+				t.Errorf("estimated similarity %1.3f, Jaccard %1.3f", estimate, jaccard)
+			}
+		})
+	}
+}
+
+func TestSignEmpty(t *testing.T) {
+	if _, ok := Sign("  ...  "); ok {
+		t.Error("Sign() of a text without words succeeded")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxDocs int
+		add     map[string]string
+		order   []string
+		query   string
+		want    []string
+	}{
+		{
+			name:  "finds near-duplicates",
+			add:   map[string]string{"1": original, "2": other},
+			order: []string{"1", "2"},
+			query: edited,
+			want:  []string{"1"},
+		},
+		{
+			name:  "most similar first",
+			add:   map[string]string{"1": edited, "2": original},
+			order: []string{"1", "2"},
+			query: original,
+			want:  []string{"2", "1"},
+		},
+		{
+			name:    "drops the oldest documents",
+			maxDocs: 1,
+			add:     map[string]string{"1": original, "2": other},
+			order:   []string{"1", "2"},
+			query:   original,
+			want:    []string{},
+		},
+		{
+			name:  "replaces known IDs",
+			add:   map[string]string{"1": original},
+			order: []string{"1", "1"},
+			query: original,
+			want:  []string{"1"},
+		},
+		{
+			name:  "nothing for texts without words",
+			add:   map[string]string{"1": original},
+			order: []string{"1"},
+			query: "!!!",
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := NewIndex(tt.maxDocs)
+			for _, id := range tt.order {
+				idx.Add(id, tt.add[id])
+			}
+
+			got := make([]string, 0)
+			for _, match := range idx.Query(tt.query, 0.5) {
+				got = append(got, match.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
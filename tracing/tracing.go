@@ -0,0 +1,147 @@
+// Package tracing records OpenTelemetry spans of the pipeline: AMQP
+//  hops, Graph API calls, database queries and ElasticSearch requests.
+//  Spans are only exported if [tracing] names an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/moensch/fbbotscan/config"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"time"
+)
+
+const tracerName = "github.com/moensch/fbbotscan"
+
+var provider *sdktrace.TracerProvider
+
+// Install the exporter set in [tracing] for the given service.
+//  Trace context is propagated in W3C traceparent headers either way.
+func Setup(cfg *config.TracingConfig, service string) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "":
+		return nil
+	case "otlp":
+		opts := make([]otlptracehttp.Option, 0)
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		f, ferr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if ferr != nil {
+			return fmt.Errorf("Cannot open trace file: %s", ferr)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return fmt.Errorf("Unknown trace exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot create %s trace exporter: %s", cfg.Exporter, err)
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Infof("Exporting traces of %s to %s", service, cfg.Exporter)
+
+	return nil
+}
+
+// Export the spans still buffered and stop the exporter
+func Shutdown() {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Errorf("Cannot flush traces: %s", err)
+	}
+}
+
+// Start a span, the caller has to End it
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End a span, marking it failed if err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record a client span for a call which already returned, e.g. one
+//  timed for metrics anyway
+func Record(ctx context.Context, name string, started time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := Start(ctx, name,
+		trace.WithTimestamp(started),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	End(span, err)
+}
+
+// Start a trace of its own for a fetched comment, beginning when it
+//  was posted on Facebook. The span ends once the comment is
+//  published, so it covers posting to fetch. Publishing it with the
+//  returned context makes storing and classifying it part of that
+//  trace, see RecordVerdict. The trace links to the fetch which found
+//  the comment.
+func StartComment(ctx context.Context, id string, created time.Time) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attribute.String("fb.comment_id", id)),
+	}
+	if !created.IsZero() {
+		opts = append(opts, trace.WithTimestamp(created))
+	}
+	return Start(ctx, "comment", opts...)
+}
+
+// Record a span from a comment being posted until its verdict was
+//  stored, in the comment's trace. Its duration is the latency from
+//  posting to verdict.
+func RecordVerdict(ctx context.Context, id string, created time.Time) {
+	_, span := Start(ctx, "verdict",
+		trace.WithTimestamp(created),
+		trace.WithAttributes(attribute.String("fb.comment_id", id)),
+	)
+	span.End()
+}
+
+// Add the trace context of ctx to message headers
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// Continue the trace found in message headers
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
@@ -0,0 +1,47 @@
+package fbbotscan
+
+import (
+	"fmt"
+	"time"
+)
+
+// A group of near-duplicate comments, usually posted by many
+//  accounts across many pages
+type Campaign struct {
+	ID        int64     `json:"id"`
+	Message   string    `json:"message"`
+	Comments  int       `json:"comments"`
+	Authors   int       `json:"authors"`
+	Pages     int       `json:"pages"`
+	FirstSeen GraphTime `json:"first_seen"`
+	LastSeen  GraphTime `json:"last_seen"`
+	Created   time.Time `json:"created"`
+}
+
+// A comment belonging to a campaign
+type CampaignMember struct {
+	CampaignID  int64     `json:"campaign_id"`
+	CommentID   string    `json:"comment_id"`
+	UserID      string    `json:"user_id"`
+	PageID      string    `json:"page_id"`
+	CreatedTime GraphTime `json:"created_time"`
+	Similarity  float64   `json:"similarity"`
+}
+
+// How long the campaign has been going on
+func (c *Campaign) Duration() time.Duration {
+	if c.FirstSeen.IsZero() || c.LastSeen.IsZero() {
+		return 0
+	}
+	return c.LastSeen.Sub(c.FirstSeen.Time)
+}
+
+func (c *Campaign) String() string {
+	return fmt.Sprintf("campaign %d: %d comments posted by %d accounts across %d pages in %s",
+		c.ID,
+		c.Comments,
+		c.Authors,
+		c.Pages,
+		c.Duration(),
+	)
+}
@@ -1,33 +1,70 @@
 package fbbotscan
 
 type FBComment struct {
-	CreatedTime string `json:"created_time"`
-	From        FBUser `json:"from"`
-	ID          string `json:"id"`
-	Message     string `json:"message"`
+	CreatedTime GraphTime `json:"created_time"`
+	From        FBUser    `json:"from"`
+	ID          string    `json:"id"`
+	Message     string    `json:"message"`
 	Parent      struct {
-		CreatedTime string `json:"created_time"`
-		From        FBUser `json:"from"`
-		ID          string `json:"id"`
-		Message     string `json:"message"`
+		CreatedTime GraphTime `json:"created_time"`
+		From        FBUser    `json:"from"`
+		ID          string    `json:"id"`
+		Message     string    `json:"message"`
 	} `json:"parent"`
-	PermalinkURL string `json:"permalink_url"`
-	CommentCount int32  `json:"comment_count"`
-	LikeCount    int32  `json:"like_count"`
+	PermalinkURL string    `json:"permalink_url"`
+	CommentCount int32     `json:"comment_count"`
+	LikeCount    int32     `json:"like_count"`
+	PageID       string    `json:"page_id,omitempty"`
+	PostID       string    `json:"post_id,omitempty"`
+	Revision     int       `json:"revision,omitempty"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	DeletedTime  GraphTime `json:"deleted_time,omitempty"`
+	Language     string    `json:"language,omitempty"`
 }
 
 type FBCommentList struct {
 	Entries []FBComment `json:"data"`
 }
 
-const CommentMapping = `
+// Bumped whenever CommentTemplate changes in a way that
+//  requires existing indices to be reindexed
+const CommentMappingVersion = 3
+
+// Index template for comment indices, a format string taking the
+//  index pattern, number of shards, number of replicas, the alias
+//  every new index joins and the mapping version
+const CommentTemplate = `
 {
+    "template": "%s",
     "settings": {
-        "number_of_replicas": 0,
-        "number_of_shards": 1
+        "number_of_shards": %d,
+        "number_of_replicas": %d,
+        "analysis": {
+            "filter": {
+                "message_shingle": {
+                    "type": "shingle",
+                    "min_shingle_size": 2,
+                    "max_shingle_size": 3,
+                    "output_unigrams": false
+                }
+            },
+            "analyzer": {
+                "message_shingles": {
+                    "type": "custom",
+                    "tokenizer": "standard",
+                    "filter": ["lowercase", "message_shingle"]
+                }
+            }
+        }
+    },
+    "aliases": {
+        "%s": {}
     },
     "mappings": {
         "fbcomment": {
+            "_meta": {
+                "version": %d
+            },
             "properties": {
                 "comment_count": {
                     "type": "long"
@@ -35,13 +72,19 @@ const CommentMapping = `
                 "created_time": {
                     "type": "date"
                 },
+                "deleted": {
+                    "type": "boolean"
+                },
+                "deleted_time": {
+                    "type": "date"
+                },
                 "from": {
                     "properties": {
                         "first_name": {
                             "type": "text"
                         },
                         "id": {
-                            "type": "text"
+                            "type": "keyword"
                         },
                         "last_name": {
                             "type": "text"
@@ -50,10 +93,16 @@ const CommentMapping = `
                             "type": "boolean"
                         },
                         "name": {
-                            "type": "text"
+                            "type": "text",
+                            "fields": {
+                                "keyword": {
+                                    "type": "keyword",
+                                    "ignore_above": 256
+                                }
+                            }
                         },
                         "name_format": {
-                            "type": "text"
+                            "type": "keyword"
                         },
                         "short_name": {
                             "type": "text"
@@ -64,26 +113,73 @@ const CommentMapping = `
                     "store": true,
                     "type": "keyword"
                 },
+                "language": {
+                    "type": "keyword"
+                },
                 "like_count": {
                     "type": "long"
                 },
                 "message": {
-                    "fielddata": true,
                     "store": true,
                     "type": "text",
-                    "term_vector": "yes"
+                    "term_vector": "yes",
+                    "fields": {
+                        "english": {
+                            "type": "text",
+                            "analyzer": "english",
+                            "term_vector": "yes"
+                        },
+                        "german": {
+                            "type": "text",
+                            "analyzer": "german",
+                            "term_vector": "yes"
+                        },
+                        "spanish": {
+                            "type": "text",
+                            "analyzer": "spanish",
+                            "term_vector": "yes"
+                        },
+                        "shingles": {
+                            "type": "text",
+                            "analyzer": "message_shingles"
+                        }
+                    }
+                },
+                "page_id": {
+                    "type": "keyword"
                 },
                 "parent": {
                     "properties": {
+                        "created_time": {
+                            "type": "date"
+                        },
+                        "from": {
+                            "properties": {
+                                "id": {
+                                    "type": "keyword"
+                                },
+                                "name": {
+                                    "type": "text"
+                                }
+                            }
+                        },
                         "id": {
                             "type": "keyword"
+                        },
+                        "message": {
+                            "type": "text"
                         }
                     }
                 },
+                "post_id": {
+                    "type": "keyword"
+                },
+                "revision": {
+                    "type": "integer"
+                },
                 "permalink_url": {
-                    "fielddata": true,
                     "store": true,
-                    "type": "text"
+                    "type": "keyword"
                 }
             }
         }
@@ -0,0 +1,15 @@
+package fbbotscan
+
+import (
+	"time"
+)
+
+// A densely connected group of authors who keep commenting on the
+//  same posts at the same time or posting the same text
+type Network struct {
+	ID      int64     `json:"id"`
+	Members []string  `json:"members"`
+	Density float64   `json:"density"`
+	Weight  float64   `json:"weight"`
+	Created time.Time `json:"created"`
+}
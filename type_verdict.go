@@ -0,0 +1,28 @@
+package fbbotscan
+
+import (
+	"time"
+)
+
+// Classification bands a verdict can fall into
+const (
+	BandDiscard = "discard"
+	BandSuspect = "suspect"
+	BandMatch   = "match"
+)
+
+// How the matched comment was found
+const (
+	MethodMLT     = "mlt"
+	MethodMinhash = "minhash"
+)
+
+type Verdict struct {
+	CommentID string    `json:"comment_id"`
+	UserID    string    `json:"user_id"`
+	MatchedID string    `json:"matched_id"`
+	Score     float64   `json:"score"`
+	Band      string    `json:"band"`
+	Method    string    `json:"method"`
+	Created   time.Time `json:"created"`
+}